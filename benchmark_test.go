@@ -8,9 +8,34 @@
 package query
 
 import (
+	"fmt"
 	"testing"
+	"time"
 )
 
+func BenchmarkQuery_AppendTo(b *testing.B) {
+	q := From(span(1, 100000))
+	var buf []interface{}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Reuse the capacity of the previous run:
+		buf = q.AppendTo(buf[:0])
+	}
+}
+
+func BenchmarkQuery_AppendTo_toSlice(b *testing.B) {
+	q := From(span(1, 100000))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Allocate a fresh slice on every run:
+		ToSlice(q)
+	}
+}
+
 func BenchmarkQuery_Expand(b *testing.B) {
 	a := shuffle(span(1, 100000))
 
@@ -63,6 +88,70 @@ func BenchmarkQuery_MapTo(b *testing.B) {
 	}
 }
 
+func BenchmarkQuery_MapTo_sleepy(b *testing.B) {
+	a := span(1, 100)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		From(a).
+			// Copy elements slowly:
+			MapTo(func(e T) T {
+				time.Sleep(100 * time.Microsecond)
+				return e
+			}).
+			// Pull the lazy iterator:
+			ForEach(func(e T) {})
+	}
+}
+
+func BenchmarkQuery_MapToParallel_sleepy(b *testing.B) {
+	a := span(1, 100)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		From(a).
+			// Copy elements slowly on 4 workers:
+			MapToParallel(4, func(e T) T {
+				time.Sleep(100 * time.Microsecond)
+				return e
+			}).
+			// Pull the lazy iterator:
+			ForEach(func(e T) {})
+	}
+}
+
+func BenchmarkQuery_RollingSumInt(b *testing.B) {
+	a := shuffle(span(1, 100000))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		From(a).
+			// Sum windows of 100 elements:
+			RollingSumInt(100).
+			// Pull the lazy iterator:
+			ForEach(func(e T) {})
+	}
+}
+
+func BenchmarkQuery_RollingSumInt_naive(b *testing.B) {
+	a := shuffle(span(1, 100000))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		From(a).
+			// Sum every full window of 100 elements:
+			ForEachWithLookahead(99, func(cur T, ahead []T) {
+				if len(ahead) < 99 {
+					return
+				}
+				sum := cur.(int)
+				for _, e := range ahead {
+					sum += e.(int)
+				}
+			})
+	}
+}
+
 func BenchmarkQuery_Sort(b *testing.B) {
 	data := shuffle(span(1, 100000))
 
@@ -77,3 +166,71 @@ func BenchmarkQuery_Sort(b *testing.B) {
 			ForEach(func(T) {})
 	}
 }
+
+func BenchmarkQuery_Sort_costlyKey(b *testing.B) {
+	data := shuffle(span(1, 100000))
+	key := func(e T) string {
+		return fmt.Sprintf("%08d", e)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		From(data).
+			// Sort random elements by a formatted key:
+			Sort(func(t1, t2 T) bool {
+				return key(t1) < key(t2)
+			}).
+			// Pull the lazy iterator:
+			ForEach(func(T) {})
+	}
+}
+
+func BenchmarkQuery_SortByKey_costlyKey(b *testing.B) {
+	data := shuffle(span(1, 100000))
+	key := func(e T) interface{} {
+		return fmt.Sprintf("%08d", e)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		From(data).
+			// Sort random elements by a formatted key:
+			SortByKey(key, func(k1, k2 interface{}) bool {
+				return k1.(string) < k2.(string)
+			}).
+			// Pull the lazy iterator:
+			ForEach(func(T) {})
+	}
+}
+
+func BenchmarkQuery_TopN(b *testing.B) {
+	data := shuffle(span(1, 100000))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		From(data).
+			// Select the 10 largest elements:
+			TopN(10, func(t1, t2 T) bool {
+				return t1.(int) < t2.(int)
+			}).
+			// Pull the lazy iterator:
+			ForEach(func(T) {})
+	}
+}
+
+func BenchmarkQuery_TopN_sortTake(b *testing.B) {
+	data := shuffle(span(1, 100000))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		From(data).
+			// Sort all elements in descending order:
+			Sort(func(t1, t2 T) bool {
+				return t1.(int) > t2.(int)
+			}).
+			// Take the 10 largest elements:
+			Take(10).
+			// Pull the lazy iterator:
+			ForEach(func(T) {})
+	}
+}
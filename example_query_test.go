@@ -8,7 +8,13 @@
 package query
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 func ExampleFrom() {
@@ -19,6 +25,69 @@ func ExampleFrom() {
 	// Got from query: [1 3 5 7 9]
 }
 
+func ExampleFromIterator() {
+	// Generate the powers of two lazily:
+	q := FromIterator(func() Iterator {
+		v := 1
+		return func() (elem T, ok bool) {
+			elem, v = v, v*2
+			return elem, true
+		}
+	})
+	fmt.Printf("Got powers: %v\n", q.Take(8))
+
+	// Output:
+	// Got powers: [1 2 4 8 16 32 64 128]
+}
+
+func ExampleFromLines() {
+	r := strings.NewReader("INFO start\nERROR disk full\nINFO stop\n")
+	q := FromLines(r)
+	v := q.Where(func(e T) bool {
+		return strings.HasPrefix(e.(string), "ERROR")
+	})
+	fmt.Printf("Got errors: %v, read error: %v\n", v, q.Err())
+
+	// Output:
+	// Got errors: [ERROR disk full], read error: <nil>
+}
+
+func ExampleMerge() {
+	q := Merge(From([]T{"header"}), From([]T{"row 1", "row 2"}), From([]T{"footer"}))
+	fmt.Printf("Got merged: %v\n", q)
+
+	// Output:
+	// Got merged: [header row 1 row 2 footer]
+}
+
+func ExampleMergeConcurrent() {
+	q := MergeConcurrent(From([]T{3, 1}), From([]T{2}))
+	less := func(a, b T) bool {
+		return a.(int) < b.(int)
+	}
+	fmt.Printf("Got merged: %v\n", q.Sort(less))
+
+	// Output:
+	// Got merged: [1 2 3]
+}
+
+func ExampleQuery_Aggregate_average() {
+	// Calculating the average of an query:
+	sum := func(v, e T) interface{} {
+		s := v.([]int)
+		return []int{s[0] + e.(int), s[1] + 1}
+	}
+	avg := func(v T) interface{} {
+		s := v.([]int)
+		return float64(s[0]) / float64(s[1])
+	}
+	v := From([]T{1, 2, 3, 4}).Aggregate([]int{0, 0}, sum, avg)
+	fmt.Printf("Aggregated elements to average: %v", v)
+
+	// Output:
+	// Aggregated elements to average: 2.5
+}
+
 func ExampleQuery_Any_odd() {
 	isEven := func(e T) bool {
 		return e.(int)&1 == 0
@@ -49,6 +118,26 @@ func ExampleQuery_Any_greatherThan() {
 	// An even number > 3 was found: true
 }
 
+func ExampleQuery_Append_footer() {
+	v := From([]T{1, 2, 3}).Append(4)
+	fmt.Printf("Appended: %v\n", v)
+
+	// Output:
+	// Appended: [1 2 3 4]
+}
+
+func ExampleQuery_AppendTo_reuse() {
+	buf := make([]interface{}, 0, 4)
+	for _, n := range []int{2, 3} {
+		buf = From([]T{1, 2, 3, 4}).Take(n).AppendTo(buf[:0])
+		fmt.Printf("Got: %v, capacity: %v\n", buf, cap(buf))
+	}
+
+	// Output:
+	// Got: [1 2], capacity: 4
+	// Got: [1 2 3], capacity: 4
+}
+
 func ExampleQuery_At_found() {
 	v := From([]T{1, 2, 3, 4, 5}).At(3)
 	fmt.Printf("Element at index 5: %v\n", v)
@@ -65,6 +154,80 @@ func ExampleQuery_At_notFound() {
 	// Element at index 15: <nil>
 }
 
+func ExampleQuery_AverageBy_score() {
+	type player struct {
+		Name  string
+		Score int
+	}
+	v := From([]T{player{"Ann", 90}, player{"Bob", 75}, player{"Cid", 84}}).
+		AverageBy(func(e T) float64 {
+			return float64(e.(player).Score)
+		})
+	fmt.Printf("Average score: %v\n", v)
+
+	// Output:
+	// Average score: 83
+}
+
+func ExampleQuery_Batch_insert() {
+	From([]T{1, 2, 3, 4, 5, 6, 7}).
+		Batch(3, func(batch []T) {
+			fmt.Printf("Insert batch: %v\n", batch)
+		})
+
+	// Output:
+	// Insert batch: [1 2 3]
+	// Insert batch: [4 5 6]
+	// Insert batch: [7]
+}
+
+func ExampleQuery_BottomN_three() {
+	less := func(a, b T) bool {
+		return a.(int) < b.(int)
+	}
+	v := From([]T{3, 1, 4, 1, 5, 9, 2, 6}).BottomN(3, less)
+	fmt.Printf("Bottom three: %v\n", v)
+
+	// Output:
+	// Bottom three: [1 1 2]
+}
+
+func ExampleQuery_Cache_count() {
+	v := 0
+	q := From([]T{1, 2, 3}).
+		MapTo(func(e T) T {
+			v++
+			return e
+		}).
+		Cache()
+	fmt.Printf("Cached query: %v %v\n", q, q)
+	fmt.Printf("Mapped elements: %v\n", v)
+
+	// Output:
+	// Cached query: [1 2 3] [1 2 3]
+	// Mapped elements: 3
+}
+
+func ExampleQuery_ChunkBy_ascending() {
+	// Split into ascending runs:
+	descending := func(prev, curr T) bool {
+		return curr.(int) < prev.(int)
+	}
+	v := From([]T{1, 2, 5, 3, 4, 1}).ChunkBy(descending)
+	fmt.Printf("Ascending runs: %v\n", v)
+
+	// Output:
+	// Ascending runs: [[1 2 5] [3 4] [1]]
+}
+
+func ExampleQuery_Compact_optional() {
+	v := From([]T{1, nil, 2, nil, 3}).Compact()
+	fmt.Printf("Compacted query: %v\n", v)
+
+	// Output:
+	// Compacted query: [1 2 3]
+}
+
 func ExampleQuery_Contains_notFound() {
 	v := From([]T{1, 2, 3, 4, 5}).Contains(3)
 	fmt.Printf("Contains 6: %v\n", v)
@@ -81,6 +244,143 @@ func ExampleQuery_Contains_found() {
 	// Contains 12: false
 }
 
+type caseless string
+
+func (c caseless) Equals(other T) bool {
+	o, ok := other.(caseless)
+	return ok && strings.EqualFold(string(c), string(o))
+}
+
+func ExampleQuery_Contains_equaler() {
+	v := From([]T{caseless("Go"), caseless("Rust")}).Contains(caseless("GO"))
+	fmt.Printf("Contains: %v\n", v)
+
+	// Output:
+	// Contains: true
+}
+
+func ExampleQuery_ContainsFunc_parity() {
+	sameParity := func(a, b T) bool {
+		return a.(int)&1 == b.(int)&1
+	}
+	v := From([]T{1, 3, 5, 7, 9}).ContainsFunc(2, sameParity)
+	fmt.Printf("An even number was found: %v\n", v)
+
+	// Output:
+	// An even number was found: false
+}
+
+func ExampleQuery_ContainsSequence_found() {
+	v := From([]T{1, 2, 3, 4, 5}).ContainsSequence(From([]T{2, 3, 4}))
+	fmt.Printf("Sequence found: %v\n", v)
+
+	// Output:
+	// Sequence found: true
+}
+
+func ExampleQuery_ContainsSequence_notFound() {
+	v := From([]T{1, 2, 3, 4, 5}).ContainsSequence(From([]T{2, 4, 3}))
+	fmt.Printf("Sequence found: %v\n", v)
+
+	// Output:
+	// Sequence found: false
+}
+
+func ExampleQuery_CountAtLeast_even() {
+	isEven := func(e T) bool {
+		return e.(int)&1 == 0
+	}
+	v := From([]T{1, 2, 3, 4, 5}).CountAtLeast(2, isEven)
+	fmt.Printf("At least two even numbers: %v\n", v)
+
+	// Output:
+	// At least two even numbers: true
+}
+
+func ExampleQuery_CountAtMost_even() {
+	isEven := func(e T) bool {
+		return e.(int)&1 == 0
+	}
+	v := From([]T{1, 2, 3, 4, 5}).CountAtMost(1, isEven)
+	fmt.Printf("At most one even number: %v\n", v)
+
+	// Output:
+	// At most one even number: false
+}
+
+func ExampleQuery_CountBy_length() {
+	v := From([]T{"go", "rust", "c", "java", "zig"}).CountBy(func(e T) interface{} {
+		return len(e.(string))
+	})
+	fmt.Printf("Names of length 4: %v\n", v[4])
+
+	// Output:
+	// Names of length 4: 2
+}
+
+func ExampleQuery_CrossJoin_cards() {
+	v := From([]T{"A", "K"}).
+		CrossJoin(From([]T{"♠", "♥"}),
+			func(o, i T) interface{} {
+				return o.(string) + i.(string)
+			})
+	fmt.Printf("Cross join: %v\n", v)
+
+	// Output:
+	// Cross join: [A♠ A♥ K♠ K♥]
+}
+
+func ExampleQuery_CumulativeMax_highs() {
+	less := func(a, b T) bool {
+		return a.(int) < b.(int)
+	}
+	v := From([]T{101, 99, 104, 102, 108}).CumulativeMax(less)
+	fmt.Printf("Highs: %v\n", v)
+
+	// Output:
+	// Highs: [101 101 104 104 108]
+}
+
+func ExampleQuery_Cycle_pattern() {
+	v := From([]T{"on", "off"}).Cycle(-1).Take(5)
+	fmt.Printf("Pattern: %v\n", v)
+
+	// Output:
+	// Pattern: [on off on off on]
+}
+
+func ExampleQuery_DefaultIfEmpty_empty() {
+	v := From([]T{}).DefaultIfEmpty(0)
+	fmt.Printf("Default if empty: %v\n", v)
+
+	// Output:
+	// Default if empty: [0]
+}
+
+func ExampleQuery_DefaultIfEmpty_notEmpty() {
+	v := From([]T{1, 2, 3}).DefaultIfEmpty(0)
+	fmt.Printf("Default if empty: %v\n", v)
+
+	// Output:
+	// Default if empty: [1 2 3]
+}
+
+func ExampleQuery_Delta_growth() {
+	v := From([]T{120, 135, 131, 160}).Delta()
+	fmt.Printf("Daily change: %v\n", v)
+
+	// Output:
+	// Daily change: [15 -4 29]
+}
+
+func ExampleQuery_DistinctUntilChanged_uniq() {
+	v := From([]T{1, 1, 2, 2, 2, 1, 3, 3}).DistinctUntilChanged()
+	fmt.Printf("Collapsed runs: %v\n", v)
+
+	// Output:
+	// Collapsed runs: [1 2 1 3]
+}
+
 func ExampleQuery_Every_allOdd() {
 	q := From([]T{1, 3, 5, 7, 9})
 	v := q.Every(func(e T) bool {
@@ -103,6 +403,21 @@ func ExampleQuery_Every_allEven() {
 	// All numbers are even: false
 }
 
+func ExampleQuery_ExactlyN_primaryKey() {
+	type Column struct {
+		Name    string
+		Primary bool
+	}
+	isPrimary := func(e T) bool {
+		return e.(Column).Primary
+	}
+	v := From([]T{Column{"id", true}, Column{"name", false}}).ExactlyN(1, isPrimary)
+	fmt.Printf("Exactly one primary key: %v\n", v)
+
+	// Output:
+	// Exactly one primary key: true
+}
+
 func ExampleQuery_Expand_null() {
 	q := From([]T{1, 2, 3, 4, 5})
 	v := q.Expand(func(e T) []T {
@@ -167,6 +482,36 @@ func ExampleQuery_First_empty() {
 	// First element: <nil>
 }
 
+func ExampleQuery_FirstWhere_found() {
+	greaterThan3 := func(e T) bool {
+		return e.(int) > 3
+	}
+	v := From([]T{1, 2, 3, 4, 5}).FirstWhere(greaterThan3)
+	fmt.Printf("First element > 3: %v\n", v)
+
+	// Output:
+	// First element > 3: 4
+}
+
+func ExampleQuery_Flatten_join() {
+	v := From([]T{1, 2, 3}).
+		Join(From([]T{2, 3, 4}),
+			func(e T) interface{} {
+				return e
+			},
+			func(e T) interface{} {
+				return e
+			},
+			func(o, i interface{}) interface{} {
+				return []T{o, i}
+			}).
+		Flatten()
+	fmt.Printf("Flattened join: %v\n", v)
+
+	// Output:
+	// Flattened join: [2 2 3 3]
+}
+
 func ExampleQuery_Fold_sum() {
 	// Calculating the sum of an query:
 	sum := func(v, e T) interface{} {
@@ -179,6 +524,34 @@ func ExampleQuery_Fold_sum() {
 	// Folded elements to sum: 6
 }
 
+func ExampleQuery_FoldIndexed_polynomial() {
+	// Evaluating 2 + 3x + 4x² at x = 10 from its coefficients:
+	x := 10
+	v := From([]T{2, 3, 4}).FoldIndexed(0, func(i int, acc, e T) interface{} {
+		p := 1
+		for k := 0; k < i; k++ {
+			p *= x
+		}
+		return acc.(int) + e.(int)*p
+	})
+	fmt.Printf("Polynomial value: %v\n", v)
+
+	// Output:
+	// Polynomial value: 432
+}
+
+func ExampleQuery_FoldTrace_sum() {
+	// Tracing the sum of an query:
+	sum := func(v, e T) interface{} {
+		return v.(int) + e.(int)
+	}
+	v := From([]T{1, 2, 3}).FoldTrace(0, sum)
+	fmt.Printf("Traced fold steps: %v", v)
+
+	// Output:
+	// Traced fold steps: [{1 1} {2 3} {3 6}]
+}
+
 func ExampleQuery_ForEach_append() {
 	v := []T{}
 	From([]T{1, 3, 5, 7, 9}).
@@ -215,25 +588,108 @@ func ExampleQuery_ForEach_sum() {
 	// For each: 6
 }
 
-func ExampleQuery_IsEmpty_empty() {
-	v := From([]T{}).IsEmpty()
-	fmt.Printf("Empty query: %v\n", v)
+func ExampleQuery_ForEachContext_cancel() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	v := 0
+	err := From([]T{1, 3, 5, 7, 9}).
+		ForEachContext(ctx, func(e T) {
+			v++
+			if e == 5 {
+				cancel()
+			}
+		})
+	fmt.Printf("For each: %v, error: %v", v, err)
 
 	// Output:
-	// Empty query: true
+	// For each: 3, error: context canceled
 }
 
-func ExampleQuery_IsEmpty_notEmpty() {
-	v := From([]T{1}).IsEmpty()
-	fmt.Printf("Empty query: %v\n", v)
+func ExampleQuery_ForEachIndexed_print() {
+	From([]T{1, 3, 5}).
+		ForEachIndexed(func(i int, e T) {
+			fmt.Printf("%v: %v\n", i, e)
+		})
 
 	// Output:
-	// Empty query: false
+	// 0: 1
+	// 1: 3
+	// 2: 5
 }
 
-func ExampleQuery_Join_inner() {
+func ExampleQuery_ForEachParallel_sum() {
+	var mu sync.Mutex
+	total := 0
+	From([]T{1, 2, 3, 4, 5}).ForEachParallel(2, func(e T) {
+		mu.Lock()
+		total += e.(int)
+		mu.Unlock()
+	})
+	fmt.Printf("Sum: %v\n", total)
+
+	// Output:
+	// Sum: 15
+}
+
+func ExampleQuery_ForEachUntil_budget() {
+	budget := 10
+	From([]T{4, 3, 5, 2}).ForEachUntil(func(e T) bool {
+		if e.(int) > budget {
+			return false
+		}
+		budget -= e.(int)
+		fmt.Printf("Bought %v, left %v\n", e, budget)
+		return true
+	})
+
+	// Output:
+	// Bought 4, left 6
+	// Bought 3, left 3
+}
+
+func ExampleQuery_ForEachWithLookahead_peek() {
+	From([]T{1, 2, 3, 4}).
+		ForEachWithLookahead(2, func(cur T, ahead []T) {
+			fmt.Printf("%v %v\n", cur, ahead)
+		})
+
+	// Output:
+	// 1 [2 3]
+	// 2 [3 4]
+	// 3 [4]
+	// 4 []
+}
+
+func ExampleQuery_Format_csv() {
+	v := From([]T{"id", "name", "year"}).Format("", ",", "\n")
+	fmt.Print(v)
+
+	// Output:
+	// id,name,year
+}
+
+func ExampleQuery_Histogram_ages() {
+	v := From([]T{23, 35, 31, 47, 52, 29, 38}).Histogram(func(e T) float64 {
+		return float64(e.(int))
+	}, 20, 60, 4)
+	fmt.Printf("Per decade: %v\n", v)
+
+	// Output:
+	// Per decade: [2 3 1 1]
+}
+
+func ExampleQuery_Indexed_at() {
+	x := From([]T{1, 2, 3, 4, 5}).Indexed()
+	fmt.Printf("Element at index 3 of %v: %v\n", x.Len(), x.At(3))
+
+	// Output:
+	// Element at index 3 of 5: 4
+}
+
+func ExampleQuery_FullOuterJoin_outer() {
 	v := From([]T{1, 2, 3, 4, 5}).
-		Join(From([]T{3, 4, 5, 6, 7}),
+		FullOuterJoin(From([]T{3, 4, 5, 6, 7}),
 			// Outer key selector:
 			func(e T) interface{} {
 				return e
@@ -246,69 +702,630 @@ func ExampleQuery_Join_inner() {
 			func(o, i interface{}) interface{} {
 				return []T{o, i}
 			})
-	fmt.Printf("Inner join: %v\n", v)
+	fmt.Printf("Full outer join: %v\n", v)
 
 	// Output:
-	// Inner join: [[3 3] [4 4] [5 5]]
+	// Full outer join: [[1 <nil>] [2 <nil>] [3 3] [4 4] [5 5] [<nil> 6] [<nil> 7]]
 }
 
-func ExampleQuery_Last_found() {
-	v := From([]T{1, 2, 3, 4, 5}).Last()
-	fmt.Printf("Last element: %v", v)
+func ExampleQuery_IndexOf_found() {
+	v := From([]T{1, 2, 3, 4, 5}).IndexOf(4)
+	fmt.Printf("Index of 4: %v\n", v)
 
 	// Output:
-	// Last element: 5
+	// Index of 4: 3
 }
 
-func ExampleQuery_Last_empty() {
-	v := From([]T{}).Last()
-	fmt.Printf("Last element: %v", v)
+func ExampleQuery_IndexOf_notFound() {
+	v := From([]T{1, 2, 3, 4, 5}).IndexOf(42)
+	fmt.Printf("Index of 42: %v\n", v)
 
 	// Output:
-	// Last element: <nil>
+	// Index of 42: -1
 }
 
-func ExampleQuery_MapTo_add() {
-	// Add a number to every slice collection element:
-	add := func(e T) T {
-		return e.(int) + 10
-	}
-	q := From([]T{1, 2, 3, 4, 5})
-	v := q.MapTo(add)
-	fmt.Printf("Map q to v: %v\n", v)
+func ExampleQuery_Insert_middle() {
+	v := From([]T{"a", "b", "d"}).Insert(2, "c")
+	fmt.Printf("Letters: %v\n", v)
 
 	// Output:
-	// Map q to v: [11 12 13 14 15]
+	// Letters: [a b c d]
 }
 
-func ExampleQuery_MapTo_addToEven() {
-	// Add a number to every even slice collection element:
-	add := func(e T) T {
-		if e.(int)%2 == 0 {
-			return e.(int) + 10
-		}
-		return e.(int)
-	}
-	q := From([]T{1, 2, 3, 4, 5})
-	v := q.MapTo(add)
-	fmt.Printf("Map q to v: %v\n", v)
+func ExampleQuery_Intersperse_comma() {
+	From([]T{"a", "b", "c"}).
+		Intersperse(", ").
+		ForEach(func(e T) {
+			fmt.Print(e)
+		})
+	fmt.Println()
 
 	// Output:
-	// Map q to v: [1 12 3 14 5]
+	// a, b, c
 }
 
-func ExampleQuery_Reduce_sum() {
-	// Calculating the sum of an query:
-	sum := func(v, e T) interface{} {
-		return v.(int) + e.(int)
-	}
-	v := From([]T{1, 2, 3}).Reduce(sum)
-	fmt.Printf("Reduced elements to sum: %v", v)
+func ExampleQuery_IsEmpty_empty() {
+	v := From([]T{}).IsEmpty()
+	fmt.Printf("Empty query: %v\n", v)
+
+	// Output:
+	// Empty query: true
+}
+
+func ExampleQuery_IsEmpty_notEmpty() {
+	v := From([]T{1}).IsEmpty()
+	fmt.Printf("Empty query: %v\n", v)
+
+	// Output:
+	// Empty query: false
+}
+
+func ExampleQuery_GroupAdjacentBy_runs() {
+	v := From([]T{"apple", "avocado", "banana", "blueberry", "cherry"}).
+		GroupAdjacentBy(func(e T) interface{} {
+			return e.(string)[0:1]
+		})
+	v.ForEach(func(e T) {
+		g := e.(Group)
+		fmt.Printf("%v: %v\n", g.Key, g.Items)
+	})
+
+	// Output:
+	// a: [apple avocado]
+	// b: [banana blueberry]
+	// c: [cherry]
+}
+
+func ExampleQuery_Join_inner() {
+	v := From([]T{1, 2, 3, 4, 5}).
+		Join(From([]T{3, 4, 5, 6, 7}),
+			// Outer key selector:
+			func(e T) interface{} {
+				return e
+			},
+			// Inner key selector:
+			func(e T) interface{} {
+				return e
+			},
+			// Result selector:
+			func(o, i interface{}) interface{} {
+				return []T{o, i}
+			})
+	fmt.Printf("Inner join: %v\n", v)
+
+	// Output:
+	// Inner join: [[3 3] [4 4] [5 5]]
+}
+
+func ExampleQuery_KthLargest_second() {
+	less := func(a, b T) bool {
+		return a.(int) < b.(int)
+	}
+	v := From([]T{3, 1, 4, 1, 5, 9, 2, 6}).KthLargest(2, less)
+	fmt.Printf("Second largest: %v\n", v)
+
+	// Output:
+	// Second largest: 6
+}
+
+func ExampleQuery_Lag_previous() {
+	v := From([]T{10, 12, 15}).Lag(1, nil)
+	fmt.Printf("Previous: %v\n", v)
+
+	// Output:
+	// Previous: [<nil> 10 12]
+}
+
+func ExampleQuery_LagPairs_two() {
+	v := From([]T{1, 2, 3, 4, 5}).LagPairs(2)
+	fmt.Printf("Lag pairs: %v\n", v)
+
+	// Output:
+	// Lag pairs: [[1 3] [2 4] [3 5]]
+}
+
+func ExampleQuery_Last_found() {
+	v := From([]T{1, 2, 3, 4, 5}).Last()
+	fmt.Printf("Last element: %v", v)
+
+	// Output:
+	// Last element: 5
+}
+
+func ExampleQuery_Last_empty() {
+	v := From([]T{}).Last()
+	fmt.Printf("Last element: %v", v)
+
+	// Output:
+	// Last element: <nil>
+}
+
+func ExampleQuery_LastWhere_found() {
+	lessThan4 := func(e T) bool {
+		return e.(int) < 4
+	}
+	v := From([]T{1, 2, 3, 4, 5}).LastWhere(lessThan4)
+	fmt.Printf("Last element < 4: %v\n", v)
+
+	// Output:
+	// Last element < 4: 3
+}
+
+func ExampleQuery_Lead_next() {
+	v := From([]T{10, 12, 15}).Lead(1, nil)
+	fmt.Printf("Next: %v\n", v)
+
+	// Output:
+	// Next: [12 15 <nil>]
+}
+
+func ExampleQuery_LeftJoin_outer() {
+	v := From([]T{1, 2, 3, 4, 5}).
+		LeftJoin(From([]T{3, 4, 5, 6, 7}),
+			// Outer key selector:
+			func(e T) interface{} {
+				return e
+			},
+			// Inner key selector:
+			func(e T) interface{} {
+				return e
+			},
+			// Result selector:
+			func(o, i interface{}) interface{} {
+				return []T{o, i}
+			})
+	fmt.Printf("Left join: %v\n", v)
+
+	// Output:
+	// Left join: [[1 <nil>] [2 <nil>] [3 3] [4 4] [5 5]]
+}
+
+func ExampleQuery_LeftOuterJoin_orders() {
+	type customer struct {
+		ID   int
+		Name string
+	}
+	type order struct {
+		CustomerID int
+		Item       string
+	}
+	v := From([]T{customer{1, "Ann"}, customer{2, "Bob"}}).
+		LeftOuterJoin(From([]T{order{1, "book"}, order{1, "pen"}}),
+			// Outer key selector:
+			func(e T) interface{} {
+				return e.(customer).ID
+			},
+			// Inner key selector:
+			func(e T) interface{} {
+				return e.(order).CustomerID
+			},
+			// Result selector:
+			func(o, i T) interface{} {
+				if i == nil {
+					return o.(customer).Name + ": -"
+				}
+				return o.(customer).Name + ": " + i.(order).Item
+			})
+	fmt.Printf("Left outer join: %v\n", v)
+
+	// Output:
+	// Left outer join: [Ann: book Ann: pen Bob: -]
+}
+
+func ExampleQuery_MapTo_add() {
+	// Add a number to every slice collection element:
+	add := func(e T) T {
+		return e.(int) + 10
+	}
+	q := From([]T{1, 2, 3, 4, 5})
+	v := q.MapTo(add)
+	fmt.Printf("Map q to v: %v\n", v)
+
+	// Output:
+	// Map q to v: [11 12 13 14 15]
+}
+
+func ExampleQuery_MapTo_addToEven() {
+	// Add a number to every even slice collection element:
+	add := func(e T) T {
+		if e.(int)%2 == 0 {
+			return e.(int) + 10
+		}
+		return e.(int)
+	}
+	q := From([]T{1, 2, 3, 4, 5})
+	v := q.MapTo(add)
+	fmt.Printf("Map q to v: %v\n", v)
+
+	// Output:
+	// Map q to v: [1 12 3 14 5]
+}
+
+func ExampleQuery_MapToIndexed_rank() {
+	// Prefix every element with its rank:
+	rank := func(i int, e T) T {
+		return fmt.Sprintf("%v. %v", i+1, e)
+	}
+	From([]T{"gold", "silver", "bronze"}).
+		MapToIndexed(rank).
+		ForEach(func(e T) {
+			fmt.Println(e)
+		})
+
+	// Output:
+	// 1. gold
+	// 2. silver
+	// 3. bronze
+}
+
+func ExampleQuery_MapToParallel_add() {
+	// Add a number to every slice collection element:
+	add := func(e T) T {
+		return e.(int) + 10
+	}
+	q := From([]T{1, 2, 3, 4, 5})
+	v := q.MapToParallel(2, add)
+	fmt.Printf("Map q to v: %v\n", v)
+
+	// Output:
+	// Map q to v: [11 12 13 14 15]
+}
+
+func ExampleQuery_MapToRetry_flaky() {
+	// Fail the first call for every element:
+	failed := map[T]bool{}
+	flaky := func(e T) (T, error) {
+		if !failed[e] {
+			failed[e] = true
+			return nil, errors.New("flaky")
+		}
+		return e.(int) + 10, nil
+	}
+	v, err := From([]T{1, 2, 3}).MapToRetry(2, time.Millisecond, flaky)
+	fmt.Printf("Map with retry: %v, error: %v\n", v, err)
+
+	// Output:
+	// Map with retry: [11 12 13], error: <nil>
+}
+
+func ExampleQuery_Materialize_snapshot() {
+	prices := []T{10, 20, 30}
+	snapshot := From(prices).Materialize()
+	prices[0] = 15
+	fmt.Printf("Live: %v, snapshot: %v\n", From(prices), snapshot)
+
+	// Output:
+	// Live: [15 20 30], snapshot: [10 20 30]
+}
+
+func ExampleQuery_MaxBy_latest() {
+	year := func(e T) interface{} {
+		return e.(Book).Year
+	}
+	less := func(a, b interface{}) bool {
+		return a.(int) < b.(int)
+	}
+	v := From([]T{
+		Book{1, "Sense & Sensibility", 1811},
+		Book{4, "Emma", 1815},
+		Book{2, "Pride & Prejudice", 1813},
+	}).MaxBy(year, less)
+	fmt.Printf("Latest book: %v\n", v.(Book).Title)
+
+	// Output:
+	// Latest book: Emma
+}
+
+func ExampleQuery_MaxByKeyMap_parity() {
+	// Largest element of each parity:
+	parity := func(e T) interface{} {
+		return e.(int) % 2
+	}
+	less := func(a, b T) bool {
+		return a.(int) < b.(int)
+	}
+	v := From([]T{1, 2, 3, 4, 5}).MaxByKeyMap(parity, less)
+	fmt.Printf("Largest even: %v, largest odd: %v\n", v[0], v[1])
+
+	// Output:
+	// Largest even: 4, largest odd: 5
+}
+
+func ExampleQuery_MergeSorted_oddEven() {
+	less := func(a, b T) bool {
+		return a.(int) < b.(int)
+	}
+	v := From([]T{1, 3, 5, 7, 9}).MergeSorted(From([]T{2, 4, 6, 8}), less)
+	fmt.Printf("Merged query: %v\n", v)
+
+	// Output:
+	// Merged query: [1 2 3 4 5 6 7 8 9]
+}
+
+func ExampleQuery_MinBy_earliest() {
+	year := func(e T) interface{} {
+		return e.(Book).Year
+	}
+	less := func(a, b interface{}) bool {
+		return a.(int) < b.(int)
+	}
+	v := From([]T{
+		Book{4, "Emma", 1815},
+		Book{1, "Sense & Sensibility", 1811},
+		Book{2, "Pride & Prejudice", 1813},
+	}).MinBy(year, less)
+	fmt.Printf("Earliest book: %v\n", v.(Book).Title)
+
+	// Output:
+	// Earliest book: Sense & Sensibility
+}
+
+func ExampleQuery_MinMax_range() {
+	less := func(a, b T) bool {
+		return a.(int) < b.(int)
+	}
+	min, max := From([]T{3, 1, 4, 1, 5, 9, 2, 6}).MinMax(less)
+	fmt.Printf("Range: [%v, %v]\n", min, max)
+
+	// Output:
+	// Range: [1, 9]
+}
+
+func ExampleQuery_Mode_color() {
+	v := From([]T{"red", "blue", "green", "blue", "red", "blue"}).Mode()
+	fmt.Printf("Most frequent: %v\n", v)
+
+	// Output:
+	// Most frequent: blue
+}
+
+func ExampleQuery_None_negative() {
+	negative := func(e T) bool {
+		return e.(int) < 0
+	}
+	v := From([]T{3, 0, 7}).None(negative)
+	fmt.Printf("No negative elements: %v\n", v)
+
+	// Output:
+	// No negative elements: true
+}
+
+func ExampleQuery_Normalize_scores() {
+	v := From([]T{40, 70, 90, 60}).Normalize()
+	fmt.Printf("Normalized: %v\n", v)
+
+	// Output:
+	// Normalized: [0 0.6 1 0.4]
+}
+
+func ExampleQuery_OfType_int() {
+	v := From([]T{1, "two", 3, "four", 5}).OfType(0)
+	fmt.Printf("Integers: %v\n", v)
+
+	// Output:
+	// Integers: [1 3 5]
+}
+
+func ExampleQuery_OrderBy_thenBy() {
+	type player struct {
+		Name  string
+		Score int
+	}
+	byScore := func(a, b T) bool {
+		return a.(player).Score > b.(player).Score
+	}
+	byName := func(a, b T) bool {
+		return a.(player).Name < b.(player).Name
+	}
+	v := From([]T{player{"Cid", 7}, player{"Bob", 9}, player{"Ann", 7}}).
+		OrderBy(byScore).
+		ThenBy(byName).
+		Query()
+	fmt.Printf("Ranking: %v\n", v)
+
+	// Output:
+	// Ranking: [{Bob 9} {Ann 7} {Cid 7}]
+}
+
+func ExampleQuery_Pad_columns() {
+	v := From([]T{"id", "name"}).Pad(4, "-")
+	fmt.Printf("Columns: %v\n", v)
+
+	// Output:
+	// Columns: [id name - -]
+}
+
+func ExampleQuery_PadLeft_digits() {
+	v := From([]T{4, 2}).PadLeft(5, 0)
+	fmt.Printf("Digits: %v\n", v)
+
+	// Output:
+	// Digits: [0 0 0 4 2]
+}
+
+func ExampleQuery_Pairwise_delta() {
+	v := From([]T{1, 4, 9, 16}).
+		Pairwise().
+		MapTo(func(e T) T {
+			p := e.([]T)
+			return p[1].(int) - p[0].(int)
+		})
+	fmt.Printf("Deltas: %v\n", v)
+
+	// Output:
+	// Deltas: [3 5 7]
+}
+
+func ExampleQuery_Partition_even() {
+	isEven := func(e T) bool {
+		return e.(int)&1 == 0
+	}
+	even, odd := From([]T{1, 2, 3, 4, 5}).Partition(isEven)
+	fmt.Printf("Even: %v, odd: %v\n", even, odd)
+
+	// Output:
+	// Even: [2 4], odd: [1 3 5]
+}
+
+func ExampleQuery_PartitionByWeight_balanced() {
+	weight := func(e T) float64 {
+		return float64(e.(int))
+	}
+	parts := From([]T{1, 2, 3, 4, 5, 6}).PartitionByWeight(2, weight)
+	fmt.Printf("Partitions: %v %v\n", parts[0], parts[1])
+
+	// Output:
+	// Partitions: [6 3 2] [5 4 1]
+}
+
+func ExampleQuery_Peekable_runs() {
+	p := From([]T{1, 1, 2, 3, 3, 3}).Peekable()
+	for elem, ok := p.Next(); ok; elem, ok = p.Next() {
+		n := 1
+		for next, ok := p.Peek(); ok && next == elem; next, ok = p.Peek() {
+			p.Next()
+			n++
+		}
+		fmt.Printf("%v x%v\n", elem, n)
+	}
+
+	// Output:
+	// 1 x2
+	// 2 x1
+	// 3 x3
+}
+
+func ExampleQuery_Percentile_latency() {
+	less := func(a, b T) bool {
+		return a.(int) < b.(int)
+	}
+	q := From([]T{12, 15, 11, 95, 14, 13, 16, 12, 18, 13})
+	fmt.Printf("Median: %vms, p90: %vms\n", q.Percentile(50, less), q.Percentile(90, less))
+
+	// Output:
+	// Median: 13ms, p90: 18ms
+}
+
+func ExampleQuery_Prepend_header() {
+	v := From([]T{1, 2, 3}).Prepend(0)
+	fmt.Printf("Prepended: %v\n", v)
+
+	// Output:
+	// Prepended: [0 1 2 3]
+}
+
+func ExampleQuery_Product_factorial() {
+	v := From([]T{1, 2, 3, 4, 5}).Product()
+	fmt.Printf("Product of elements: %v\n", v)
+
+	// Output:
+	// Product of elements: 120
+}
+
+func ExampleQuery_Reduce_sum() {
+	// Calculating the sum of an query:
+	sum := func(v, e T) interface{} {
+		return v.(int) + e.(int)
+	}
+	v := From([]T{1, 2, 3}).Reduce(sum)
+	fmt.Printf("Reduced elements to sum: %v", v)
 
 	// Output:
 	// Reduced elements to sum: 6
 }
 
+func ExampleQuery_ReduceOK_empty() {
+	sum := func(v, e T) interface{} {
+		return v.(int) + e.(int)
+	}
+	v, ok := From([]T{}).ReduceOK(sum)
+	fmt.Printf("Reduced: %v, ok: %v\n", v, ok)
+
+	// Output:
+	// Reduced: <nil>, ok: false
+}
+
+func ExampleQuery_ReduceRight_path() {
+	// Nesting the elements from the right:
+	nest := func(e, acc T) interface{} {
+		return fmt.Sprintf("(%v %v)", e, acc)
+	}
+	v := From([]T{"a", "b", "c"}).ReduceRight(nest)
+	fmt.Printf("Reduced elements to: %v", v)
+
+	// Output:
+	// Reduced elements to: (a (b c))
+}
+
+func ExampleQuery_RemoveAt_second() {
+	v := From([]T{"a", "b", "c"}).RemoveAt(1)
+	fmt.Printf("Letters: %v\n", v)
+
+	// Output:
+	// Letters: [a c]
+}
+
+func ExampleQuery_Replace_first() {
+	v := From([]T{"draft", "draft", "final"}).Replace("draft", "review", 1)
+	fmt.Printf("States: %v\n", v)
+
+	// Output:
+	// States: [review draft final]
+}
+
+func ExampleQuery_RollingSumInt_three() {
+	v := From([]T{1, 2, 3, 4, 5}).RollingSumInt(3)
+	fmt.Printf("Rolling sums: %v\n", v)
+
+	// Output:
+	// Rolling sums: [6 9 12]
+}
+
+func ExampleQuery_Rotate_weekdays() {
+	v := From([]T{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}).Rotate(1)
+	fmt.Printf("Week starting Monday: %v\n", v)
+
+	// Output:
+	// Week starting Monday: [Mon Tue Wed Thu Fri Sat Sun]
+}
+
+func ExampleQuery_Sample_all() {
+	v := From([]T{1, 2, 3}).Sample(5, 42)
+	fmt.Printf("Sampled elements: %v\n", v)
+
+	// Output:
+	// Sampled elements: [1 2 3]
+}
+
+func ExampleQuery_Scan_sum() {
+	// Calculating the prefix sums of an query:
+	sum := func(v, e T) interface{} {
+		return v.(int) + e.(int)
+	}
+	v := From([]T{1, 2, 3, 4}).Scan(0, sum)
+	fmt.Printf("Scanned elements to sums: %v", v)
+
+	// Output:
+	// Scanned elements to sums: [1 3 6 10]
+}
+
+func ExampleQuery_SelectMany_tags() {
+	type post struct {
+		Title string
+		Tags  []T
+	}
+	posts := []T{post{"Generics", []T{"go", "types"}}, post{"Iterators", []T{"go"}}}
+	v := From(posts).SelectMany(func(e T) []T {
+		return e.(post).Tags
+	}, func(src, item T) interface{} {
+		return fmt.Sprintf("%v:%v", item, src.(post).Title)
+	})
+	fmt.Printf("Tagged: %v\n", v)
+
+	// Output:
+	// Tagged: [go:Generics types:Generics go:Iterators]
+}
+
 func ExampleQuery_Skip_found() {
 	v := From([]T{1, 2, 3, 4, 5}).Skip(2)
 	fmt.Printf("Skipped 5 elements: %v", v)
@@ -325,6 +1342,14 @@ func ExampleQuery_Skip_empty() {
 	// Skipped 5 elements: []
 }
 
+func ExampleQuery_Slice_odd() {
+	v := From([]T{"a", "b", "c", "d", "e", "f", "g"}).Slice(1, -1, 2)
+	fmt.Printf("Slice: %v\n", v)
+
+	// Output:
+	// Slice: [b d f]
+}
+
 func ExampleQuery_Sort_decreasing() {
 	decreasing := func(e, f T) bool {
 		return e.(int) > f.(int)
@@ -347,6 +1372,84 @@ func ExampleQuery_Sort_increasing() {
 	// [1 2 3 4 5 6 7 8 9]
 }
 
+func ExampleQuery_SortByKey_year() {
+	year := func(e T) interface{} {
+		return e.(Book).Year
+	}
+	less := func(a, b interface{}) bool {
+		return a.(int) < b.(int)
+	}
+	From([]T{
+		Book{4, "Emma", 1815},
+		Book{1, "Sense & Sensibility", 1811},
+		Book{2, "Pride & Prejudice", 1813},
+	}).
+		SortByKey(year, less).
+		ForEach(func(e T) {
+			fmt.Println(e.(Book).Title)
+		})
+
+	// Output:
+	// Sense & Sensibility
+	// Pride & Prejudice
+	// Emma
+}
+
+func ExampleQuery_SortCmp_lengthThenAlpha() {
+	v := From([]T{"pear", "fig", "apple", "kiwi"}).SortCmp(func(a, b T) int {
+		if d := len(a.(string)) - len(b.(string)); d != 0 {
+			return d
+		}
+		return strings.Compare(a.(string), b.(string))
+	})
+	fmt.Printf("Sorted: %v\n", v)
+
+	// Output:
+	// Sorted: [fig kiwi pear apple]
+}
+
+func ExampleQuery_SortDescending_natural() {
+	less := func(e1, e2 T) bool {
+		return e1.(int) < e2.(int)
+	}
+	v := From([]T{5, 3, 1, 4, 2}).SortDescending(less)
+	fmt.Printf("Sorted query: %v\n", v)
+
+	// Output:
+	// Sorted query: [5 4 3 2 1]
+}
+
+func ExampleQuery_SplitAt_half() {
+	head, tail := From([]T{1, 2, 3, 4, 5}).SplitAt(2)
+	fmt.Printf("Head: %v, tail: %v\n", head, tail)
+
+	// Output:
+	// Head: [1 2], tail: [3 4 5]
+}
+
+func ExampleQuery_StepBy_three() {
+	v := From([]T{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}).StepBy(3)
+	fmt.Printf("Every third element: %v\n", v)
+
+	// Output:
+	// Every third element: [1 4 7 10]
+}
+
+func ExampleQuery_SumBy_pages() {
+	type chapter struct {
+		Title string
+		Pages int
+	}
+	v := From([]T{chapter{"Intro", 12}, chapter{"Usage", 30}, chapter{"Outro", 5}}).
+		SumBy(func(e T) int {
+			return e.(chapter).Pages
+		})
+	fmt.Printf("Total pages: %v\n", v)
+
+	// Output:
+	// Total pages: 47
+}
+
 func ExampleQuery_Take_some() {
 	v := From([]T{1, 2, 3, 4, 5}).Take(3)
 	fmt.Printf("Taken elements: %v", v)
@@ -363,6 +1466,116 @@ func ExampleQuery_Take_all() {
 	// Taken elements: [1 2 3 4 5]
 }
 
+func ExampleQuery_TakeLast_some() {
+	v := From([]T{1, 2, 3, 4, 5}).TakeLast(3)
+	fmt.Printf("Took last elements: %v\n", v)
+
+	// Output:
+	// Took last elements: [3 4 5]
+}
+
+func ExampleQuery_Tally_votes() {
+	v := From([]T{"yes", "no", "yes", "yes", "abstain"}).Tally()
+	fmt.Printf("Yes: %v, no: %v, abstain: %v\n", v["yes"], v["no"], v["abstain"])
+
+	// Output:
+	// Yes: 3, no: 1, abstain: 1
+}
+
+func ExampleQuery_Tap_log() {
+	v := From([]T{1, 2, 3, 4, 5}).
+		Where(func(e T) bool {
+			return e.(int)&1 == 1
+		}).
+		Tap(func(e T) {
+			fmt.Printf("Passed: %v\n", e)
+		}).
+		Sort(func(e1, e2 T) bool {
+			return e1.(int) > e2.(int)
+		})
+	fmt.Printf("Sorted query: %v\n", v)
+
+	// Output:
+	// Passed: 1
+	// Passed: 3
+	// Passed: 5
+	// Sorted query: [5 3 1]
+}
+
+func ExampleQuery_Tee_twice() {
+	all, odd := From([]T{1, 2, 3, 4, 5}).Tee()
+	v := odd.Where(func(e T) bool {
+		return e.(int)&1 == 1
+	})
+	fmt.Printf("All: %v, odd: %v\n", all, v)
+
+	// Output:
+	// All: [1 2 3 4 5], odd: [1 3 5]
+}
+
+func ExampleQuery_ToIntSlice_sum() {
+	a, err := From([]T{1, 2, 3}).ToIntSlice()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	total := 0
+	for _, v := range a {
+		total += v
+	}
+	fmt.Printf("Sum: %v\n", total)
+
+	// Output:
+	// Sum: 6
+}
+
+func ExampleQuery_ToStringSlice_mixed() {
+	_, err := From([]T{"a", 'b', "c"}).ToStringSlice()
+	fmt.Println(err)
+
+	// Output:
+	// query: element 1 is int32, not string
+}
+
+func ExampleQuery_TopN_three() {
+	less := func(a, b T) bool {
+		return a.(int) < b.(int)
+	}
+	v := From([]T{3, 1, 4, 1, 5, 9, 2, 6}).TopN(3, less)
+	fmt.Printf("Top three: %v\n", v)
+
+	// Output:
+	// Top three: [9 6 5]
+}
+
+func ExampleQuery_TryMapTo_parse() {
+	q := From([]T{"1", "2", "three", "4"}).
+		TryMapTo(func(e T) (T, error) {
+			return strconv.Atoi(e.(string))
+		})
+	v := ToSlice(q)
+	fmt.Printf("Parsed: %v, error: %v\n", v, q.Err())
+
+	// Output:
+	// Parsed: [1 2], error: strconv.Atoi: parsing "three": invalid syntax
+}
+
+func ExampleQuery_WeightedAverage_price() {
+	type order struct {
+		Price    float64
+		Quantity float64
+	}
+	v := From([]T{order{10, 3}, order{20, 1}}).WeightedAverage(func(e T) float64 {
+		return e.(order).Price
+	}, func(e T) float64 {
+		return e.(order).Quantity
+	})
+	fmt.Printf("Average price per unit: %v\n", v)
+
+	// Output:
+	// Average price per unit: 12.5
+}
+
 func ExampleQuery_Where_greaterThan() {
 	where := func(e T) bool {
 		return e.(int) > 3
@@ -384,3 +1597,45 @@ func ExampleQuery_Where_lessThan() {
 	// Output:
 	// Where: []
 }
+
+func ExampleQuery_WhereIndexed_everyOther() {
+	evenIndex := func(i int, e T) bool {
+		return i%2 == 0
+	}
+	v := From([]T{1, 2, 3, 4, 5, 6}).WhereIndexed(evenIndex)
+	fmt.Printf("Every other element: %v\n", v)
+
+	// Output:
+	// Every other element: [1 3 5]
+}
+
+func ExampleQuery_WhereNot_hidden() {
+	hidden := func(e T) bool {
+		return strings.HasPrefix(e.(string), ".")
+	}
+	v := From([]T{".git", "main.go", ".env", "go.mod"}).WhereNot(hidden)
+	fmt.Printf("Visible files: %v\n", v)
+
+	// Output:
+	// Visible files: [main.go go.mod]
+}
+
+func ExampleQuery_WithPosition_separators() {
+	From([]T{1, 2, 3}).
+		WithPosition().
+		ForEach(func(e T) {
+			p := e.(Position)
+			if p.First {
+				fmt.Print("[")
+			}
+			fmt.Print(p.Value)
+			if p.Last {
+				fmt.Println("]")
+			} else {
+				fmt.Print(", ")
+			}
+		})
+
+	// Output:
+	// [1, 2, 3]
+}
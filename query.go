@@ -10,8 +10,20 @@
 package query
 
 import (
+	"bufio"
+	"container/heap"
+	"context"
+	"database/sql"
 	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"reflect"
+	"runtime"
 	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
 // T is an interface that has to be implemented by a custom collection in
@@ -24,6 +36,9 @@ type Iterator func() (elem T, ok bool)
 // Query is the type returned from query functions. It can be iterated manually.
 type Query struct {
 	Iterate func() Iterator
+	src     []*Query
+	mu      sync.Mutex
+	err     error
 }
 
 // String converts the query to a string.
@@ -31,24 +46,93 @@ func (q *Query) String() string {
 	return fmt.Sprintf("%v", ToSlice(q))
 }
 
+// Err returns the error which ended the last iteration of the query, if any.
+//
+// Only error-aware sources and operators like FromLines, FromRows and TryMapTo set
+// an error. Queries derived from them by other operators report the error of their
+// sources, so Err may be called on the end of a chain after terminal methods
+// like ToSlice or ForEach have drained it.
+func (q *Query) Err() error {
+	q.mu.Lock()
+	err := q.err
+	q.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	for _, src := range q.src {
+		if err := src.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setErr records the error which ended the current iteration of the query.
+// Operators call it with nil when an iteration starts to reset the error.
+// It is safe for concurrent use.
+func (q *Query) setErr(err error) {
+	q.mu.Lock()
+	q.err = err
+	q.mu.Unlock()
+}
+
+// Aggregate reduces a collection to a single value like Fold and
+// projects the final value with the result selector.
+//
+// An empty collection returns resultSel(v).
+func (q *Query) Aggregate(v T, f func(v, e T) interface{}, resultSel func(v T) interface{}) interface{} {
+	return resultSel(q.Fold(v, f))
+}
+
 // Any checks whether any element of this collection satisfies all predicates.
 //
 // Checks every element in iteration order, and returns true
 // if any of them make test return true, otherwise returns false.
+// Nil predicates are skipped, as if they always returned true.
 func (q *Query) Any(f ...func(e T) bool) bool {
 	next := q.Iterate()
 	for elem, ok := next(); ok; elem, ok = next() {
-		has := true
-		for k := 0; k < len(f); k++ {
-			has = has && f[k](elem)
-		}
-		if has {
+		if matches(f, elem) {
 			return true
 		}
 	}
 	return false
 }
 
+// Append returns a lazy Query which yields the elements of this Query
+// followed by the element e.
+func (q *Query) Append(e T) *Query {
+	iterate := func() Iterator {
+		return appendTo(q, e)
+	}
+	return &Query{Iterate: iterate, src: []*Query{q}}
+}
+
+func appendTo(q *Query, e T) Iterator {
+	next := q.Iterate()
+	done := false
+	return func() (elem T, ok bool) {
+		if elem, ok = next(); ok || done {
+			return
+		}
+		done = true
+		return e, true
+	}
+}
+
+// AppendTo appends the elements of this collection in iteration order to dst
+// and returns the extended slice, like the built-in append.
+//
+// Passing a slice truncated to zero length reuses its capacity, which avoids
+// allocations when a Query is materialized repeatedly.
+func (q *Query) AppendTo(dst []interface{}) []interface{} {
+	next := q.Iterate()
+	for elem, ok := next(); ok; elem, ok = next() {
+		dst = append(dst, elem)
+	}
+	return dst
+}
+
 // At returns the ith element.
 //
 // The index i must be non-negative and less than length.
@@ -56,271 +140,2364 @@ func (q *Query) Any(f ...func(e T) bool) bool {
 //
 // May iterate through the elements in iteration order,
 // ignoring the first i elements and then returning the next.
-func (q *Query) At(i int) (elem T) {
+//
+// Returns nil if i is out of range.
+func (q *Query) At(i int) T {
+	elem, _ := at(q, i)
+	return elem
+}
+
+// at returns the ith element and whether it was found.
+// The element is nil whenever it was not found.
+func at(q *Query, i int) (elem T, ok bool) {
 	if i < 0 {
-		return nil
+		return
 	}
 	next := q.Iterate()
 	for ; i >= 0; i-- {
-		elem, _ = next()
+		if elem, ok = next(); !ok {
+			return nil, false
+		}
 	}
 	return
 }
 
-// Contains returns true if the collection contains an element equal to element.
-// This operation will check each element in order for being equal to element,
-// unless it has a more efficient way to find an element equal to element.
-func (q *Query) Contains(e T) bool {
+// AverageBy returns the arithmetic mean of the values that sel selects
+// from each element, or 0 if there are no elements.
+func (q *Query) AverageBy(sel func(e T) float64) float64 {
+	s, n := 0.0, 0
 	next := q.Iterate()
 	for elem, ok := next(); ok; elem, ok = next() {
-		if elem == e {
-			return true
-		}
+		s += sel(elem)
+		n++
 	}
-	return false
-}
-
-// IsEmpty returns true if there are no elements in this collection.
-func (q *Query) IsEmpty() bool {
-	next := q.Iterate()
-	_, ok := next()
-	return !ok
+	if n == 0 {
+		return 0
+	}
+	return s / float64(n)
 }
 
-// Every checks whether every element of this collection satisfies all tests.
-// Checks every element in iteration order, and returns false
-// if any of them make test return false, otherwise returns true.
-func (q *Query) Every(f ...func(e T) bool) bool {
+// Batch collects the elements into batches of size elements in iteration order
+// and applies the function flush to each batch.
+//
+// The last batch holds the remaining elements and may be smaller than size.
+// An empty collection does not call flush at all.
+//
+// A size < 1 is treated as 1.
+func (q *Query) Batch(size int, flush func(batch []T)) {
+	if size < 1 {
+		size = 1
+	}
+	var batch []T
 	next := q.Iterate()
-	has := true
 	for elem, ok := next(); ok; elem, ok = next() {
-		for k := 0; k < len(f); k++ {
-			has = has && f[k](elem)
+		batch = append(batch, elem)
+		if len(batch) == size {
+			flush(batch)
+			batch = nil
 		}
 	}
-	return has
+	if len(batch) > 0 {
+		flush(batch)
+	}
 }
 
-// Expand expands each element of this Query into zero or more elements.
+// BottomN returns a lazy query of the n smallest elements by the less function
+// in ascending order.
 //
-// The resulting Query runs through the elements returned by f
-// for each element of this, in iteration order.
+// It is the dual of TopN and selects the elements in a single pass with a bounded heap.
+// The returned Query contains all elements sorted if this contains fewer than n elements,
+// and is empty if n <= 0.
+func (q *Query) BottomN(n int, less func(a, b T) bool) *Query {
+	greater := func(a, b T) bool {
+		return less(b, a)
+	}
+	iterate := func() Iterator {
+		return from(selectN(q, n, greater))
+	}
+	return &Query{Iterate: iterate, src: []*Query{q}}
+}
+
+// Cache returns a Query which iterates this Query only once.
 //
-// The returned Query is lazy, and calls f
-// for each element of this every time it's iterated.
-func (q *Query) Expand(f func(e T) []T) *Query {
+// The first iteration materializes all elements of this into an internal slice,
+// subsequent iterations replay the slice without running this Query again.
+//
+// The returned Query is not safe for concurrent use: the first iteration
+// must complete before it is iterated again.
+func (q *Query) Cache() *Query {
+	var a []T
+	cached := false
 	iterate := func() Iterator {
-		return expand(q, f)
+		if !cached {
+			a = []T{}
+			q.ForEach(func(e T) {
+				a = append(a, e)
+			})
+			cached = true
+		}
+		return from(a)
 	}
-	return &Query{iterate}
+	return &Query{Iterate: iterate, src: []*Query{q}}
 }
 
-type expState struct {
-	outer T
-	inner []T
-	i     int
-	len   int
+// ChunkBy returns a lazy Query which groups consecutive elements into []T chunks,
+// starting a new chunk whenever boundary(previous, current) returns true.
+//
+// Each chunk is emitted once its boundary or the end of this Query is reached.
+func (q *Query) ChunkBy(boundary func(prev, curr T) bool) *Query {
+	iterate := func() Iterator {
+		return chunkBy(q, boundary)
+	}
+	return &Query{Iterate: iterate, src: []*Query{q}}
 }
 
-func expand(q *Query, f func(e T) []T) Iterator {
+func chunkBy(q *Query, boundary func(prev, curr T) bool) Iterator {
 	next := q.Iterate()
-	s := expState{}
-
+	var chunk []T
 	return func() (elem T, ok bool) {
-		for {
-			if s.i >= s.len {
-				s.outer, ok = next()
-				if !ok {
-					return
-				}
-				s.inner = f(s.outer)
-				s.len = len(s.inner)
-				s.i = 0
+		for elem, ok = next(); ok; elem, ok = next() {
+			if len(chunk) > 0 && boundary(chunk[len(chunk)-1], elem) {
+				flushed := chunk
+				chunk = []T{elem}
+				return flushed, true
 			}
+			chunk = append(chunk, elem)
+		}
+		if len(chunk) > 0 {
+			flushed := chunk
+			chunk = nil
+			return flushed, true
+		}
+		return
+	}
+}
 
-			if s.i < s.len {
-				elem = s.inner[s.i]
-				s.i++
-				return elem, true
+// Compact returns a new lazy Query with all elements that are not nil.
+//
+// Only nil interface values are dropped; typed nil values like
+// a nil pointer of a concrete type are kept.
+func (q *Query) Compact() *Query {
+	iterate := func() Iterator {
+		return where(q, []func(e T) bool{func(e T) bool {
+			return e != nil
+		}})
+	}
+	return &Query{Iterate: iterate, src: []*Query{q}}
+}
+
+// Equaler is implemented by elements which define their own equality.
+//
+// Contains and the other methods which compare elements for equality prefer
+// the Equals method over == when an element implements Equaler.
+type Equaler interface {
+	Equals(other T) bool
+}
+
+// Contains returns true if the collection contains an element equal to element.
+// This operation will check each element in order for being equal to element,
+// unless it has a more efficient way to find an element equal to element.
+//
+// Elements implementing Equaler are compared with their Equals method.
+// Other elements of comparable types are compared with ==, elements of
+// non-comparable types like slices are compared with reflect.DeepEqual.
+func (q *Query) Contains(e T) bool {
+	return q.ContainsFunc(e, equals)
+}
+
+// ContainsFunc returns true if the collection contains an element
+// for which eq reports equality with element.
+func (q *Query) ContainsFunc(e T, eq func(a, b T) bool) bool {
+	next := q.Iterate()
+	for elem, ok := next(); ok; elem, ok = next() {
+		if eq(elem, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// equals compares a and b with the Equals method if either of them implements Equaler,
+// with == if their dynamic type is comparable, otherwise with reflect.DeepEqual.
+func equals(a, b T) bool {
+	if ea, ok := a.(Equaler); ok {
+		return ea.Equals(b)
+	}
+	if eb, ok := b.(Equaler); ok {
+		return eb.Equals(a)
+	}
+	if reflect.TypeOf(a) != reflect.TypeOf(b) {
+		return false
+	}
+	if isComparable(reflect.ValueOf(a)) {
+		return a == b
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// isComparable reports whether v can be compared with == without panicking.
+//
+// Unlike reflect.Type.Comparable, it inspects the dynamic values held by
+// interfaces, so a struct with an interface field holding a slice is not comparable.
+func isComparable(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return true
+	case reflect.Interface:
+		return isComparable(v.Elem())
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !isComparable(v.Field(i)) {
+				return false
 			}
 		}
+		return true
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if !isComparable(v.Index(i)) {
+				return false
+			}
+		}
+		return true
 	}
+	return v.Type().Comparable()
 }
 
-// First returns the first element.
-func (q *Query) First() (first T) {
+// ContainsSequence returns true if the elements of pattern appear as a
+// contiguous run within this collection.
+//
+// Slides a window of the pattern's length over the elements in iteration order
+// and compares it element by element like Contains, which takes O(n*m) time.
+// An empty pattern is contained in every collection.
+func (q *Query) ContainsSequence(pattern *Query) bool {
+	p := ToSlice(pattern)
+	if len(p) == 0 {
+		return true
+	}
+	window := make([]T, 0, len(p))
 	next := q.Iterate()
-	first, _ = next()
-	return
+	for elem, ok := next(); ok; elem, ok = next() {
+		if len(window) == len(p) {
+			window = append(window[:0], window[1:]...)
+		}
+		window = append(window, elem)
+		if len(window) == len(p) && equalSeq(window, p) {
+			return true
+		}
+	}
+	return false
 }
 
-// Fold reduces a collection to a single value by iteratively combining
-// each element of the collection with an existing value.
+func equalSeq(a []T, b []interface{}) bool {
+	for i := range a {
+		if !equals(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// CountAtLeast checks whether at least k elements of this collection satisfy all predicates.
 //
-// Uses v as the initial value, then iterates through the elements
-// and updates the value with each element using the combine function.
-func (q *Query) Fold(v T, f func(v, e T) interface{}) interface{} {
+// Checks every element in iteration order, and returns true
+// as soon as the kth matching element is found, otherwise returns false.
+// Nil predicates are skipped, as if they always returned true.
+func (q *Query) CountAtLeast(k int, f ...func(e T) bool) bool {
+	if k <= 0 {
+		return true
+	}
 	next := q.Iterate()
 	for elem, ok := next(); ok; elem, ok = next() {
-		v = f(v, elem)
+		if matches(f, elem) {
+			if k--; k == 0 {
+				return true
+			}
+		}
 	}
-	return v
+	return false
 }
 
-// ForEach applies the function f to each element of this collection in iteration order.
-func (q *Query) ForEach(f func(e T)) {
+// CountAtMost checks whether at most k elements of this collection satisfy all predicates.
+//
+// Checks every element in iteration order, and returns false
+// as soon as the (k+1)th matching element is found, otherwise returns true.
+// Nil predicates are skipped, as if they always returned true.
+func (q *Query) CountAtMost(k int, f ...func(e T) bool) bool {
+	if k < 0 {
+		return false
+	}
 	next := q.Iterate()
 	for elem, ok := next(); ok; elem, ok = next() {
-		f(elem)
+		if matches(f, elem) {
+			if k--; k < 0 {
+				return false
+			}
+		}
 	}
+	return true
 }
 
-// From initializes a query with passed slice as the source.
-func From(a []T) *Query {
+// CountBy returns the number of elements for each key returned from keySel.
+//
+// Iterates through the elements once and tallies them by key.
+// The keys must be comparable.
+//
+// An empty Query returns an empty map.
+func (q *Query) CountBy(keySel func(e T) interface{}) map[interface{}]int {
+	result := make(map[interface{}]int)
+	next := q.Iterate()
+	for elem, ok := next(); ok; elem, ok = next() {
+		result[keySel(elem)]++
+	}
+	return result
+}
+
+// CrossJoin returns a lazy Query which pairs each element of this Query with
+// every element of inner, calling resultSel for each pair.
+//
+// CrossJoin preserves the order of the elements of this collection, and for each of
+// these elements, the order of the elements of inner. The inner collection is
+// materialized once per iteration.
+func (q *Query) CrossJoin(inner *Query, resultSel func(o, i T) interface{}) *Query {
 	iterate := func() Iterator {
-		return from(a)
+		a := ToSlice(inner)
+		return expand(q, func(o T) []T {
+			r := make([]T, len(a))
+			for k := range a {
+				r[k] = resultSel(o, a[k])
+			}
+			return r
+		}, nil)
 	}
-	return &Query{iterate}
+	return &Query{Iterate: iterate, src: []*Query{q, inner}}
 }
 
-func from(a []T) Iterator {
-	i := 0
+// CumulativeMax returns a lazy Query with the largest element by the less function
+// seen so far after each element of this Query.
+//
+// Of equal elements the first one seen keeps being emitted.
+func (q *Query) CumulativeMax(less func(a, b T) bool) *Query {
+	iterate := func() Iterator {
+		return cumulativeMax(q, less)
+	}
+	return &Query{Iterate: iterate, src: []*Query{q}}
+}
+
+func cumulativeMax(q *Query, less func(a, b T) bool) Iterator {
+	next := q.Iterate()
+	var max T
+	seen := false
 	return func() (elem T, ok bool) {
-		ok = i < len(a)
-		if ok {
-			elem = a[i]
-			i++
+		if elem, ok = next(); ok {
+			if !seen || less(max, elem) {
+				max, seen = elem, true
+			}
+			return max, ok
 		}
 		return
 	}
 }
 
-// Join correlates the elements of two collection based on matching keys.
-//
-// A join refers to the operation of correlating the elements of two sources of
-// information based on a common key. Join brings the two information sources
-// and the keys by which they are matched together in one method call.
+// Cycle returns a lazy Query which repeats the elements of this Query times times,
+// or infinitely if times < 0.
 //
-// Join preserves the order of the elements of outer collection, and for each of
-// these elements, the order of the matching elements of inner.
-func (q *Query) Join(inner *Query,
-	outKeySel func(e T) interface{},
-	innKeySel func(e T) interface{},
-	resultSel func(o, i interface{}) interface{}) *Query {
+// Each iteration materializes the elements of this into a slice first and replays it,
+// so this is iterated only once per iteration. Use Take to bound an infinite cycle.
+// A times of 0 yields an empty Query.
+func (q *Query) Cycle(times int) *Query {
 	iterate := func() Iterator {
-		return join(q, inner, outKeySel, innKeySel, resultSel)
+		return cycle(q, times)
 	}
-	return &Query{iterate}
+	return &Query{Iterate: iterate, src: []*Query{q}}
 }
 
-type lut map[T][]T
-
-func makeLut(it Iterator, f func(e T) interface{}) (result lut) {
-	next := it
-	result = make(lut)
-
-	for elem, ok := next(); ok; elem, ok = next() {
-		key := f(elem)
-		result[key] = append(result[key], elem)
+func cycle(q *Query, times int) Iterator {
+	var a []T
+	loaded := false
+	i, round := 0, 0
+	return func() (elem T, ok bool) {
+		if times == 0 {
+			return
+		}
+		if !loaded {
+			q.ForEach(func(e T) {
+				a = append(a, e)
+			})
+			loaded = true
+		}
+		if len(a) == 0 {
+			return
+		}
+		if i == len(a) {
+			i = 0
+			round++
+		}
+		if times > 0 && round >= times {
+			return
+		}
+		elem, ok = a[i], true
+		i++
+		return
 	}
-	return
 }
 
-type joinState struct {
-	outer T
-	inner []T
-	i     int
-	len   int
+// DefaultIfEmpty returns a lazy Query which yields the single element def
+// if this Query is empty, otherwise the elements of this Query unchanged.
+func (q *Query) DefaultIfEmpty(def T) *Query {
+	iterate := func() Iterator {
+		return defaultIfEmpty(q, def)
+	}
+	return &Query{Iterate: iterate, src: []*Query{q}}
 }
 
-func join(q *Query, inner *Query,
-	outKeySel func(e T) interface{},
-	innKeySel func(e T) interface{},
-	resultSel func(o, i interface{}) interface{}) Iterator {
+func defaultIfEmpty(q *Query, def T) Iterator {
 	next := q.Iterate()
-	lut := makeLut(inner.Iterate(), innKeySel)
-	s := joinState{}
-
+	first := true
 	return func() (elem T, ok bool) {
-		if s.i >= s.len {
-			has := false
-			for !has {
-				s.outer, ok = next()
-				if !ok {
-					return
-				}
-				s.inner, has = lut[outKeySel(s.outer)]
-				s.len = len(s.inner)
-				s.i = 0
+		elem, ok = next()
+		if first {
+			first = false
+			if !ok {
+				return def, true
 			}
 		}
-		elem = resultSel(s.outer, s.inner[s.i])
-		s.i++
-		return elem, true
+		return
+	}
+}
+
+// Delta returns a lazy Query with the differences curr - prev of each pair of
+// adjacent elements, so it has one element less than this Query.
+//
+// All elements must be of type int.
+func (q *Query) Delta() *Query {
+	iterate := func() Iterator {
+		return delta(q)
+	}
+	return &Query{Iterate: iterate, src: []*Query{q}}
+}
+
+func delta(q *Query) Iterator {
+	next := q.Iterate()
+	var prev T
+	started := false
+	return func() (elem T, ok bool) {
+		if !started {
+			started = true
+			if prev, ok = next(); !ok {
+				return
+			}
+		}
+		if elem, ok = next(); ok {
+			d := elem.(int) - prev.(int)
+			prev = elem
+			return d, ok
+		}
+		return
+	}
+}
+
+// DistinctUntilChanged returns a lazy Query which skips each element
+// equal to its predecessor, collapsing runs of equal elements into one.
+//
+// Elements are compared like in Contains.
+func (q *Query) DistinctUntilChanged() *Query {
+	iterate := func() Iterator {
+		return distinctUntilChanged(q)
+	}
+	return &Query{Iterate: iterate, src: []*Query{q}}
+}
+
+func distinctUntilChanged(q *Query) Iterator {
+	next := q.Iterate()
+	var prev T
+	first := true
+	return func() (elem T, ok bool) {
+		for elem, ok = next(); ok; elem, ok = next() {
+			if first || !equals(elem, prev) {
+				first = false
+				prev = elem
+				return
+			}
+		}
+		return
+	}
+}
+
+// Histogram returns the number of elements in each of buckets equal-width bins
+// between min and max, assigning each element by the value that sel selects from it.
+//
+// Each bin includes its lower bound, the last bin also includes max.
+// Values below min are clamped to the first bin, values above max to the last bin,
+// which includes infinities. NaN values fall into the first bin.
+// If max <= min, all values fall into the first bin.
+// A buckets < 1 returns an empty slice.
+func (q *Query) Histogram(sel func(e T) float64, min, max float64, buckets int) []int {
+	if buckets < 1 {
+		return []int{}
+	}
+	counts := make([]int, buckets)
+	next := q.Iterate()
+	for elem, ok := next(); ok; elem, ok = next() {
+		k := 0
+		if max > min {
+			// Clamp before converting, as int overflows for huge or infinite values.
+			x := (sel(elem) - min) / (max - min) * float64(buckets)
+			if x >= float64(buckets) {
+				k = buckets - 1
+			} else if x > 0 {
+				k = int(x)
+			}
+		}
+		counts[k]++
+	}
+	return counts
+}
+
+// IndexedQuery is a materialized Query which supports random access in constant time.
+type IndexedQuery struct {
+	a []interface{}
+}
+
+// Indexed materializes the elements of this Query into an IndexedQuery.
+//
+// The elements are iterated once, so subsequent calls of IndexedQuery.At
+// do not re-iterate this Query.
+func (q *Query) Indexed() *IndexedQuery {
+	return &IndexedQuery{ToSlice(q)}
+}
+
+// At returns the ith element, or nil if i is out of range.
+func (x *IndexedQuery) At(i int) T {
+	if i < 0 || i >= len(x.a) {
+		return nil
+	}
+	return x.a[i]
+}
+
+// Len returns the number of elements.
+func (x *IndexedQuery) Len() int {
+	return len(x.a)
+}
+
+// IndexOf returns the index of the first element equal to e, or -1 if there is none.
+//
+// Elements are compared like in Contains.
+func (q *Query) IndexOf(e T) int {
+	i := 0
+	next := q.Iterate()
+	for elem, ok := next(); ok; elem, ok = next() {
+		if equals(elem, e) {
+			return i
+		}
+		i++
+	}
+	return -1
+}
+
+// Insert returns a lazy Query which yields the elements of this Query
+// with the element e inserted before position i.
+//
+// An i < 0 is clamped to 0, so e becomes the first element.
+// If i is greater than or equal to the length, e is appended.
+func (q *Query) Insert(i int, e T) *Query {
+	iterate := func() Iterator {
+		return insert(q, i, e)
+	}
+	return &Query{Iterate: iterate, src: []*Query{q}}
+}
+
+func insert(q *Query, i int, e T) Iterator {
+	next := q.Iterate()
+	pos := 0
+	done := false
+	if i < 0 {
+		i = 0
+	}
+	return func() (elem T, ok bool) {
+		if !done && pos == i {
+			done = true
+			return e, true
+		}
+		if elem, ok = next(); ok || done {
+			pos++
+			return
+		}
+		done = true
+		return e, true
+	}
+}
+
+// Intersperse returns a lazy Query which yields the elements of this Query
+// with the separator sep between each two consecutive elements.
+func (q *Query) Intersperse(sep T) *Query {
+	iterate := func() Iterator {
+		return intersperse(q, sep)
+	}
+	return &Query{Iterate: iterate, src: []*Query{q}}
+}
+
+func intersperse(q *Query, sep T) Iterator {
+	next := q.Iterate()
+	var pending T
+	hasPending, started := false, false
+	return func() (elem T, ok bool) {
+		if hasPending {
+			hasPending = false
+			return pending, true
+		}
+		if elem, ok = next(); !ok || !started {
+			started = true
+			return
+		}
+		pending, hasPending = elem, true
+		return sep, true
+	}
+}
+
+// IsEmpty returns true if there are no elements in this collection.
+func (q *Query) IsEmpty() bool {
+	next := q.Iterate()
+	_, ok := next()
+	return !ok
+}
+
+// Every checks whether every element of this collection satisfies all tests.
+// Checks every element in iteration order, and returns false
+// if any of them make test return false, otherwise returns true.
+// Nil predicates are skipped, as if they always returned true.
+func (q *Query) Every(f ...func(e T) bool) bool {
+	next := q.Iterate()
+	for elem, ok := next(); ok; elem, ok = next() {
+		if !matches(f, elem) {
+			return false
+		}
+	}
+	return true
+}
+
+// ExactlyN checks whether exactly n elements of this collection satisfy all predicates.
+//
+// Checks every element in iteration order, and returns false
+// as soon as more than n matching elements are found.
+// Nil predicates are skipped, as if they always returned true.
+func (q *Query) ExactlyN(n int, f ...func(e T) bool) bool {
+	if n < 0 {
+		return false
+	}
+	next := q.Iterate()
+	for elem, ok := next(); ok; elem, ok = next() {
+		if matches(f, elem) {
+			if n--; n < 0 {
+				return false
+			}
+		}
+	}
+	return n == 0
+}
+
+// Expand expands each element of this Query into zero or more elements.
+//
+// The resulting Query runs through the elements returned by f
+// for each element of this, in iteration order.
+//
+// The returned Query is lazy, and calls f
+// for each element of this every time it's iterated.
+func (q *Query) Expand(f func(e T) []T) *Query {
+	iterate := func() Iterator {
+		return expand(q, f, nil)
+	}
+	return &Query{Iterate: iterate, src: []*Query{q}}
+}
+
+type expState struct {
+	outer T
+	inner []T
+	i     int
+	len   int
+}
+
+// expand returns an iterator over the elements returned by f for each element of q.
+// If resultSel is not nil, each of these elements is projected together with its
+// originating element of q.
+func expand(q *Query, f func(e T) []T, resultSel func(src, item T) interface{}) Iterator {
+	next := q.Iterate()
+	s := expState{}
+
+	return func() (elem T, ok bool) {
+		for {
+			if s.i >= s.len {
+				s.outer, ok = next()
+				if !ok {
+					return
+				}
+				s.inner = f(s.outer)
+				s.len = len(s.inner)
+				s.i = 0
+			}
+
+			if s.i < s.len {
+				elem = s.inner[s.i]
+				s.i++
+				if resultSel != nil {
+					elem = resultSel(s.outer, elem)
+				}
+				return elem, true
+			}
+		}
+	}
+}
+
+// First returns the first element.
+func (q *Query) First() (first T) {
+	next := q.Iterate()
+	first, _ = next()
+	return
+}
+
+// FirstWhere returns the first element that satisfies all predicate tests.
+//
+// Checks every element in iteration order, and returns the first one
+// which makes all tests return true, otherwise returns nil.
+// Nil predicates are skipped, as if they always returned true.
+func (q *Query) FirstWhere(f ...func(e T) bool) T {
+	next := q.Iterate()
+	for elem, ok := next(); ok; elem, ok = next() {
+		if matches(f, elem) {
+			return elem
+		}
+	}
+	return nil
+}
+
+// Flatten returns a lazy Query which unwraps slice elements by one level.
+//
+// Elements of type []T or []interface{} are replaced by their members,
+// all other elements are passed through unchanged.
+func (q *Query) Flatten() *Query {
+	return q.Expand(func(e T) []T {
+		switch v := e.(type) {
+		case []T:
+			return v
+		case []interface{}:
+			a := make([]T, len(v))
+			for i := range v {
+				a[i] = v[i]
+			}
+			return a
+		}
+		return []T{e}
+	})
+}
+
+// Fold reduces a collection to a single value by iteratively combining
+// each element of the collection with an existing value.
+//
+// Uses v as the initial value, then iterates through the elements
+// and updates the value with each element using the combine function.
+func (q *Query) Fold(v T, f func(v, e T) interface{}) interface{} {
+	next := q.Iterate()
+	for elem, ok := next(); ok; elem, ok = next() {
+		v = f(v, elem)
+	}
+	return v
+}
+
+// FoldIndexed reduces a collection to a single value like Fold,
+// and additionally passes the zero-based index of each element to f.
+func (q *Query) FoldIndexed(v T, f func(i int, acc, e T) interface{}) interface{} {
+	i := 0
+	next := q.Iterate()
+	for elem, ok := next(); ok; elem, ok = next() {
+		v = f(i, v, elem)
+		i++
+	}
+	return v
+}
+
+// FoldStep is the element type of the Query returned by FoldTrace.
+// It holds an input element and the accumulator value after combining it.
+type FoldStep struct {
+	Input T
+	Acc   interface{}
+}
+
+// FoldTrace returns a new lazy Query which traces a Fold step by step.
+//
+// Uses v as the initial value, then iterates through the elements
+// and emits a FoldStep with each element and the value updated by
+// the combine function.
+func (q *Query) FoldTrace(v T, f func(v, e T) interface{}) *Query {
+	iterate := func() Iterator {
+		return foldTrace(q, v, f)
+	}
+	return &Query{Iterate: iterate, src: []*Query{q}}
+}
+
+func foldTrace(q *Query, v T, f func(v, e T) interface{}) Iterator {
+	next := q.Iterate()
+	return func() (elem T, ok bool) {
+		elem, ok = next()
+		if ok {
+			v = f(v, elem)
+			return FoldStep{elem, v}, ok
+		}
+		return
+	}
+}
+
+// ForEach applies the function f to each element of this collection in iteration order.
+func (q *Query) ForEach(f func(e T)) {
+	next := q.Iterate()
+	for elem, ok := next(); ok; elem, ok = next() {
+		f(elem)
+	}
+}
+
+// ForEachContext applies the function f to each element of this collection in iteration order
+// as long as the context ctx is not done.
+//
+// Checks ctx before starting the iteration and before pulling each further element,
+// and returns ctx.Err() once it is done, otherwise returns nil after all elements were applied.
+// A done ctx therefore does not pull any element, even from an empty collection.
+func (q *Query) ForEachContext(ctx context.Context, f func(e T)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	next := q.Iterate()
+	for elem, ok := next(); ok; elem, ok = next() {
+		f(elem)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ForEachIndexed applies the function f to each element of this collection in iteration order,
+// passing the zero-based index of the element.
+func (q *Query) ForEachIndexed(f func(i int, e T)) {
+	next := q.Iterate()
+	i := 0
+	for elem, ok := next(); ok; elem, ok = next() {
+		f(i, elem)
+		i++
+	}
+}
+
+// ForEachParallel applies the function f to each element of this collection,
+// using workers goroutines.
+//
+// The function f may be called in any order and must be safe for concurrent use.
+// ForEachParallel returns after all calls of f have returned.
+//
+// A workers < 1 is treated as 1.
+func (q *Query) ForEachParallel(workers int, f func(e T)) {
+	if workers < 1 {
+		workers = 1
+	}
+	jobs := make(chan T, workers)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for elem := range jobs {
+				f(elem)
+			}
+		}()
+	}
+	next := q.Iterate()
+	for elem, ok := next(); ok; elem, ok = next() {
+		jobs <- elem
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// ForEachUntil applies the function f to each element of this collection in iteration order
+// until f returns false.
+//
+// The iteration stops immediately, so the remaining elements are never pulled.
+func (q *Query) ForEachUntil(f func(e T) bool) {
+	next := q.Iterate()
+	for elem, ok := next(); ok; elem, ok = next() {
+		if !f(elem) {
+			return
+		}
+	}
+}
+
+// ForEachWithLookahead applies the function f to each element of this collection
+// in iteration order, passing up to k of the following elements as ahead.
+//
+// Near the end of the collection ahead holds fewer than k elements.
+// The ahead slice is reused between calls and must not be retained by f.
+//
+// A negative k is treated as zero.
+func (q *Query) ForEachWithLookahead(k int, f func(cur T, ahead []T)) {
+	if k < 0 {
+		k = 0
+	}
+	next := q.Iterate()
+	var buf []T
+	ok := true
+	for ok && len(buf) <= k {
+		var elem T
+		if elem, ok = next(); ok {
+			buf = append(buf, elem)
+		}
+	}
+	for len(buf) > 0 {
+		f(buf[0], buf[1:])
+		buf = append(buf[:0], buf[1:]...)
+		if ok {
+			var elem T
+			if elem, ok = next(); ok {
+				buf = append(buf, elem)
+			}
+		}
+	}
+}
+
+// Format returns the elements formatted with %v and joined by sep,
+// enclosed in prefix and suffix.
+//
+// Unlike String, the elements are written directly while iterating,
+// without collecting them into a slice first.
+func (q *Query) Format(prefix, sep, suffix string) string {
+	var b strings.Builder
+	b.WriteString(prefix)
+	next := q.Iterate()
+	if elem, ok := next(); ok {
+		fmt.Fprintf(&b, "%v", elem)
+		for elem, ok = next(); ok; elem, ok = next() {
+			b.WriteString(sep)
+			fmt.Fprintf(&b, "%v", elem)
+		}
+	}
+	b.WriteString(suffix)
+	return b.String()
+}
+
+// From initializes a query with passed slice as the source.
+func From(a []T) *Query {
+	iterate := func() Iterator {
+		return from(a)
+	}
+	return &Query{Iterate: iterate}
+}
+
+// snapshot initializes a query with the elements a collected from an iteration
+// of another query, keeping the error which ended that iteration for Err.
+func snapshot(a []T, err error) *Query {
+	r := From(a)
+	r.err = err
+	return r
+}
+
+func from(a []T) Iterator {
+	i := 0
+	return func() (elem T, ok bool) {
+		ok = i < len(a)
+		if ok {
+			elem = a[i]
+			i++
+		}
+		return
+	}
+}
+
+// FromIterator initializes a query with a custom lazy source.
+//
+// The function iterate is called once per iteration and must return a fresh Iterator,
+// which allows plugging in generators or paginated sources without a slice.
+func FromIterator(iterate func() Iterator) *Query {
+	return &Query{Iterate: iterate}
+}
+
+// FromLines initializes a query with the lines read from r as the source.
+//
+// Each line is yielded lazily as a string without its line ending.
+// If reading fails, the iteration ends and the error is returned by Err.
+// Since r can only be read once, the returned Query can only be iterated once.
+func FromLines(r io.Reader) *Query {
+	q := &Query{}
+	s := bufio.NewScanner(r)
+	q.Iterate = func() Iterator {
+		return fromLines(q, s)
+	}
+	return q
+}
+
+func fromLines(q *Query, s *bufio.Scanner) Iterator {
+	return func() (elem T, ok bool) {
+		if s.Scan() {
+			return s.Text(), true
+		}
+		q.setErr(s.Err())
+		return
+	}
+}
+
+// FromRows initializes a query with the result set rows as the source.
+//
+// Each row is scanned lazily into a map from column name to value.
+// The rows are closed once they are exhausted or a row fails to scan.
+// If a row fails to scan or the rows report an error, the iteration ends
+// and the error is returned by Err.
+// Since rows can only be read once, the returned Query can only be iterated once.
+func FromRows(rows *sql.Rows) (*Query, error) {
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	q := &Query{}
+	q.Iterate = func() Iterator {
+		return fromRows(q, rows, cols)
+	}
+	return q, nil
+}
+
+func fromRows(q *Query, rows *sql.Rows, cols []string) Iterator {
+	done := false
+	return func() (elem T, ok bool) {
+		if done {
+			return
+		}
+		if !rows.Next() {
+			q.setErr(rows.Err())
+			rows.Close()
+			done = true
+			return
+		}
+		values := make([]interface{}, len(cols))
+		ptrs := make([]interface{}, len(cols))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			q.setErr(err)
+			rows.Close()
+			done = true
+			return
+		}
+		row := make(map[string]interface{}, len(cols))
+		for i, col := range cols {
+			row[col] = values[i]
+		}
+		return row, true
+	}
+}
+
+// FullOuterJoin correlates the elements of two collection based on matching keys,
+// keeping the elements of both collections without a match.
+//
+// FullOuterJoin works like Join, except that for each outer element without any
+// matching inner element resultSel is called once with a nil inner element,
+// and, after all outer elements, for each inner element without any matching
+// outer element resultSel is called once with a nil outer element.
+//
+// FullOuterJoin preserves the order of the elements of outer collection, and for each of
+// these elements, the order of the matching elements of inner. The unmatched inner
+// elements follow in the order of inner.
+func (q *Query) FullOuterJoin(inner *Query,
+	outKeySel func(e T) interface{},
+	innKeySel func(e T) interface{},
+	resultSel func(o, i interface{}) interface{}) *Query {
+	iterate := func() Iterator {
+		return fullOuterJoin(q, inner, outKeySel, innKeySel, resultSel)
+	}
+	return &Query{Iterate: iterate, src: []*Query{q, inner}}
+}
+
+func fullOuterJoin(q *Query, inner *Query,
+	outKeySel func(e T) interface{},
+	innKeySel func(e T) interface{},
+	resultSel func(o, i interface{}) interface{}) Iterator {
+	var a []T
+	inner.ForEach(func(e T) {
+		a = append(a, e)
+	})
+	lut := makeLut(from(a), innKeySel)
+	matched := make(map[interface{}]bool)
+	next := q.Iterate()
+	rest := from(a)
+	done := false
+	s := joinState{}
+
+	return func() (elem T, ok bool) {
+		if s.i < s.len {
+			elem = resultSel(s.outer, s.inner[s.i])
+			s.i++
+			return elem, true
+		}
+		if !done {
+			if s.outer, ok = next(); ok {
+				key := outKeySel(s.outer)
+				s.inner = lut[key]
+				s.len = len(s.inner)
+				s.i = 0
+				if s.len == 0 {
+					return resultSel(s.outer, nil), true
+				}
+				matched[key] = true
+				elem = resultSel(s.outer, s.inner[s.i])
+				s.i++
+				return elem, true
+			}
+			done = true
+		}
+		for elem, ok = rest(); ok; elem, ok = rest() {
+			if !matched[innKeySel(elem)] {
+				return resultSel(nil, elem), true
+			}
+		}
+		return
+	}
+}
+
+// Group is the element type of the Query returned by GroupAdjacentBy.
+// It holds a key and the consecutive elements sharing that key.
+type Group struct {
+	Key   interface{}
+	Items []T
+}
+
+// GroupAdjacentBy returns a lazy Query which groups consecutive elements
+// with equal keys into Group values, starting a new group whenever the key changes.
+//
+// Unlike a full grouping, elements with the same key that are not adjacent end up
+// in different groups, so it suits data that is already sorted by the key.
+// Keys are compared like elements in Contains.
+func (q *Query) GroupAdjacentBy(keySel func(e T) interface{}) *Query {
+	iterate := func() Iterator {
+		return groupAdjacentBy(q, keySel)
+	}
+	return &Query{Iterate: iterate, src: []*Query{q}}
+}
+
+func groupAdjacentBy(q *Query, keySel func(e T) interface{}) Iterator {
+	next := q.Iterate()
+	var group *Group
+	return func() (elem T, ok bool) {
+		for elem, ok = next(); ok; elem, ok = next() {
+			key := keySel(elem)
+			if group != nil && !equals(group.Key, key) {
+				flushed := *group
+				group = &Group{key, []T{elem}}
+				return flushed, true
+			}
+			if group == nil {
+				group = &Group{key, nil}
+			}
+			group.Items = append(group.Items, elem)
+		}
+		if group != nil {
+			flushed := *group
+			group = nil
+			return flushed, true
+		}
+		return
+	}
+}
+
+// Join correlates the elements of two collection based on matching keys.
+//
+// A join refers to the operation of correlating the elements of two sources of
+// information based on a common key. Join brings the two information sources
+// and the keys by which they are matched together in one method call.
+//
+// Join preserves the order of the elements of outer collection, and for each of
+// these elements, the order of the matching elements of inner.
+func (q *Query) Join(inner *Query,
+	outKeySel func(e T) interface{},
+	innKeySel func(e T) interface{},
+	resultSel func(o, i interface{}) interface{}) *Query {
+	iterate := func() Iterator {
+		return join(q, inner, outKeySel, innKeySel, resultSel, false)
+	}
+	return &Query{Iterate: iterate, src: []*Query{q, inner}}
+}
+
+type lut map[T][]T
+
+func makeLut(it Iterator, f func(e T) interface{}) (result lut) {
+	next := it
+	result = make(lut)
+
+	for elem, ok := next(); ok; elem, ok = next() {
+		key := f(elem)
+		result[key] = append(result[key], elem)
+	}
+	return
+}
+
+type joinState struct {
+	outer T
+	inner []T
+	i     int
+	len   int
+}
+
+func join(q *Query, inner *Query,
+	outKeySel func(e T) interface{},
+	innKeySel func(e T) interface{},
+	resultSel func(o, i interface{}) interface{},
+	left bool) Iterator {
+	next := q.Iterate()
+	lut := makeLut(inner.Iterate(), innKeySel)
+	s := joinState{}
+
+	return func() (elem T, ok bool) {
+		if s.i >= s.len {
+			has := false
+			for !has {
+				s.outer, ok = next()
+				if !ok {
+					return
+				}
+				s.inner, has = lut[outKeySel(s.outer)]
+				if !has && left {
+					// Keep the unmatched outer element with a nil inner.
+					s.inner, has = []T{nil}, true
+				}
+				s.len = len(s.inner)
+				s.i = 0
+			}
+		}
+		elem = resultSel(s.outer, s.inner[s.i])
+		s.i++
+		return elem, true
+	}
+}
+
+// KthLargest returns the kth largest element by the less function, counting from 1,
+// or nil if k is out of range.
+//
+// The element is selected in a single pass with a bounded heap of size k,
+// which is cheaper than sorting all elements.
+func (q *Query) KthLargest(k int, less func(a, b T) bool) T {
+	if k <= 0 {
+		return nil
+	}
+	h := largestN(q, k, less)
+	if h.Len() < k {
+		return nil
+	}
+	return h.a[0]
+}
+
+// Lag returns a lazy Query which yields for each element the element
+// offset positions earlier, or fill for the first offset elements.
+//
+// The returned Query has as many elements as this, and an offset <= 0
+// yields the elements unchanged. Only the last offset elements are buffered.
+func (q *Query) Lag(offset int, fill T) *Query {
+	iterate := func() Iterator {
+		return lag(q, offset, fill)
+	}
+	return &Query{Iterate: iterate, src: []*Query{q}}
+}
+
+func lag(q *Query, offset int, fill T) Iterator {
+	next := q.Iterate()
+	if offset <= 0 {
+		return next
+	}
+	var ring []T
+	i := 0
+	return func() (elem T, ok bool) {
+		if elem, ok = next(); ok {
+			if len(ring) < offset {
+				ring = append(ring, elem)
+				return fill, ok
+			}
+			earlier := ring[i]
+			ring[i] = elem
+			i = (i + 1) % offset
+			return earlier, ok
+		}
+		return
+	}
+}
+
+// LagPairs returns a lazy Query which pairs each element with the element
+// lag positions earlier as []T{earlier, current}.
+//
+// The first lag elements have no earlier element and yield no pair,
+// so the returned Query is empty if this has no more than lag elements.
+// A lag <= 0 pairs each element with itself.
+func (q *Query) LagPairs(lag int) *Query {
+	iterate := func() Iterator {
+		return lagPairs(q, lag)
+	}
+	return &Query{Iterate: iterate, src: []*Query{q}}
+}
+
+func lagPairs(q *Query, lag int) Iterator {
+	next := q.Iterate()
+	if lag <= 0 {
+		return func() (elem T, ok bool) {
+			elem, ok = next()
+			if ok {
+				return []T{elem, elem}, ok
+			}
+			return
+		}
+	}
+	var ring []T
+	i := 0
+	return func() (elem T, ok bool) {
+		for elem, ok = next(); ok; elem, ok = next() {
+			if len(ring) < lag {
+				ring = append(ring, elem)
+				continue
+			}
+			earlier := ring[i]
+			ring[i] = elem
+			i = (i + 1) % lag
+			return []T{earlier, elem}, ok
+		}
+		return
+	}
+}
+
+// Last returns the last element.
+func (q *Query) Last() (last T) {
+	next := q.Iterate()
+	for elem, ok := next(); ok; elem, ok = next() {
+		last = elem
+	}
+	return
+}
+
+// LastWhere returns the last element that satisfies all predicate tests.
+//
+// Checks every element in iteration order, and returns the last one
+// which makes all tests return true, otherwise returns nil.
+// Nil predicates are skipped, as if they always returned true.
+func (q *Query) LastWhere(f ...func(e T) bool) (last T) {
+	next := q.Iterate()
+	for elem, ok := next(); ok; elem, ok = next() {
+		if matches(f, elem) {
+			last = elem
+		}
+	}
+	return
+}
+
+// Lead returns a lazy Query which yields for each element the element
+// offset positions later, or fill for the last offset elements.
+//
+// The returned Query has as many elements as this, and an offset <= 0
+// yields the elements unchanged. The first offset elements are read ahead
+// before the first element is yielded.
+func (q *Query) Lead(offset int, fill T) *Query {
+	iterate := func() Iterator {
+		return lead(q, offset, fill)
+	}
+	return &Query{Iterate: iterate, src: []*Query{q}}
+}
+
+func lead(q *Query, offset int, fill T) Iterator {
+	next := q.Iterate()
+	skipped := -1
+	return func() (elem T, ok bool) {
+		if skipped < 0 {
+			for skipped = 0; skipped < offset; skipped++ {
+				if _, ok = next(); !ok {
+					break
+				}
+			}
+		}
+		if elem, ok = next(); ok {
+			return
+		}
+		if skipped > 0 {
+			skipped--
+			return fill, true
+		}
+		return
+	}
+}
+
+// LeftJoin correlates the elements of two collection based on matching keys,
+// keeping the elements of this collection without a match.
+//
+// LeftJoin works like Join, except that for each outer element without
+// any matching inner element resultSel is called once with a nil inner element.
+//
+// LeftJoin preserves the order of the elements of outer collection, and for each of
+// these elements, the order of the matching elements of inner.
+func (q *Query) LeftJoin(inner *Query,
+	outKeySel func(e T) interface{},
+	innKeySel func(e T) interface{},
+	resultSel func(o, i interface{}) interface{}) *Query {
+	iterate := func() Iterator {
+		return join(q, inner, outKeySel, innKeySel, resultSel, true)
+	}
+	return &Query{Iterate: iterate, src: []*Query{q, inner}}
+}
+
+// LeftOuterJoin correlates the elements of two collection based on matching keys,
+// keeping the elements of this collection without a match.
+//
+// For each outer element the matching inner elements are looked up as a group,
+// and an empty group is replaced by a single nil element like in DefaultIfEmpty,
+// so resultSel is called once with a nil inner element for an unmatched outer element.
+// The emitted pairs are the same as the ones of LeftJoin, but resultSel receives
+// both elements as T, like the result selector of SelectMany.
+//
+// LeftOuterJoin preserves the order of the elements of outer collection, and for each of
+// these elements, the order of the matching elements of inner.
+func (q *Query) LeftOuterJoin(inner *Query,
+	outKeySel func(e T) interface{},
+	innKeySel func(e T) interface{},
+	resultSel func(o T, i T) interface{}) *Query {
+	iterate := func() Iterator {
+		lut := makeLut(inner.Iterate(), innKeySel)
+		unmatched := []T{nil}
+		return expand(q, func(o T) []T {
+			if group := lut[outKeySel(o)]; len(group) > 0 {
+				return group
+			}
+			return unmatched
+		}, resultSel)
+	}
+	return &Query{Iterate: iterate, src: []*Query{q, inner}}
+}
+
+// MapTo returns a new lazy Query with elements that are created by
+// calling f on each element of this Query in iteration order.
+//
+// This method returns a view of the mapped elements.
+// As long as the returned Iterable is not iterated over,
+// the supplied function f will not be invoked.
+// The transformed elements will not be cached.
+// Iterating multiple times over the returned Iterable will invoke
+// the supplied function f multiple times on the same element.
+//
+// Methods on the returned query are allowed to omit
+// calling f on any element where the result isn't needed.
+//
+// A nil f maps each element to itself.
+func (q *Query) MapTo(f func(e T) T) *Query {
+	iterate := func() Iterator {
+		if f == nil {
+			return q.Iterate()
+		}
+		return mapTo(q, f)
+	}
+	return &Query{Iterate: iterate, src: []*Query{q}}
+}
+
+func mapTo(q *Query, f func(e T) T) Iterator {
+	next := q.Iterate()
+	return func() (elem T, ok bool) {
+		elem, ok = next()
+		if ok {
+			return f(elem), ok
+		}
+		return
+	}
+}
+
+// MapToIndexed returns a new lazy Query with elements that are created by
+// calling f on each element of this Query and its zero-based index in iteration order.
+//
+// Like MapTo, the transformed elements will not be cached and
+// the index restarts at zero for every iteration.
+func (q *Query) MapToIndexed(f func(i int, e T) T) *Query {
+	iterate := func() Iterator {
+		return mapToIndexed(q, f)
+	}
+	return &Query{Iterate: iterate, src: []*Query{q}}
+}
+
+func mapToIndexed(q *Query, f func(i int, e T) T) Iterator {
+	next := q.Iterate()
+	i := 0
+	return func() (elem T, ok bool) {
+		elem, ok = next()
+		if ok {
+			elem = f(i, elem)
+			i++
+		}
+		return
+	}
+}
+
+// MapToParallel returns a new lazy Query with elements that are created by
+// calling f on each element of this Query, using workers goroutines.
+//
+// The elements keep their iteration order. Since ordered parallelism
+// needs buffering, each iteration materializes all elements of this
+// and waits for all calls of f before returning the first element.
+// The function f must be safe for concurrent use.
+//
+// A workers < 1 is treated as 1.
+func (q *Query) MapToParallel(workers int, f func(e T) T) *Query {
+	iterate := func() Iterator {
+		return mapToParallel(q, workers, f)
+	}
+	return &Query{Iterate: iterate, src: []*Query{q}}
+}
+
+func mapToParallel(q *Query, workers int, f func(e T) T) Iterator {
+	if workers < 1 {
+		workers = 1
+	}
+	a := ToSlice(q)
+	jobs := make(chan int, workers)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				a[i] = f(a[i])
+			}
+		}()
+	}
+	for i := range a {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	i := 0
+	return func() (elem T, ok bool) {
+		ok = i < len(a)
+		if ok {
+			elem = a[i]
+			i++
+		}
+		return
+	}
+}
+
+// MapToRetry returns a new Query with elements that are created by
+// calling f on each element of this Query in iteration order.
+//
+// Each call of f that fails is retried up to attempts times in total,
+// waiting backoff between the tries. If an element does not succeed
+// within the given attempts, the last error is returned and the Query is nil.
+//
+// This method is eager and calls f for every element immediately.
+func (q *Query) MapToRetry(attempts int, backoff time.Duration, f func(e T) (T, error)) (*Query, error) {
+	if attempts < 1 {
+		attempts = 1
+	}
+	a := []T{}
+	next := q.Iterate()
+	for elem, ok := next(); ok; elem, ok = next() {
+		v, err := retry(attempts, backoff, elem, f)
+		if err != nil {
+			return nil, fmt.Errorf("query: element %v failed after %d attempts: %w", elem, attempts, err)
+		}
+		a = append(a, v)
+	}
+	return snapshot(a, q.Err()), nil
+}
+
+func retry(attempts int, backoff time.Duration, e T, f func(e T) (T, error)) (v T, err error) {
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			time.Sleep(backoff)
+		}
+		if v, err = f(e); err == nil {
+			return
+		}
+	}
+	return
+}
+
+// Materialize eagerly iterates this Query once and returns a Query over a
+// snapshot of its elements.
+//
+// Unlike Cache, which defers the iteration to the first use of the returned Query,
+// the snapshot is taken immediately, so later changes of the source do not affect it.
+func (q *Query) Materialize() *Query {
+	a := []T{}
+	q.ForEach(func(e T) {
+		a = append(a, e)
+	})
+	return snapshot(a, q.Err())
+}
+
+// MaxBy returns the element with the largest key, or nil if there are no elements.
+//
+// The key of each element is selected once by keySel and compared with less.
+// Of elements with equal keys the first one in iteration order is returned.
+func (q *Query) MaxBy(keySel func(e T) interface{}, less func(a, b interface{}) bool) T {
+	return extremeBy(q, keySel, func(a, b interface{}) bool {
+		return less(b, a)
+	})
+}
+
+// MaxByKeyMap returns the maximum element of each key group.
+//
+// Iterates through the elements and groups them by the key returned from keySel.
+// Within each group the element which is largest by the less function is kept.
+// Of equal elements the first one seen in iteration order is kept.
+//
+// An empty Query returns an empty map.
+func (q *Query) MaxByKeyMap(keySel func(e T) interface{}, less func(a, b T) bool) map[interface{}]T {
+	result := make(map[interface{}]T)
+	next := q.Iterate()
+	for elem, ok := next(); ok; elem, ok = next() {
+		key := keySel(elem)
+		if max, has := result[key]; !has || less(max, elem) {
+			result[key] = elem
+		}
+	}
+	return result
+}
+
+// Merge returns a lazy Query which yields the elements of all queries,
+// one query after another in argument order.
+//
+// Each query is only iterated once the preceding one is exhausted.
+// Merge without arguments returns an empty Query.
+func Merge(queries ...*Query) *Query {
+	iterate := func() Iterator {
+		return merge(queries)
+	}
+	return &Query{Iterate: iterate, src: queries}
+}
+
+func merge(queries []*Query) Iterator {
+	var next Iterator
+	i := 0
+	return func() (elem T, ok bool) {
+		for ; i < len(queries); i++ {
+			if next == nil {
+				next = queries[i].Iterate()
+			}
+			if elem, ok = next(); ok {
+				return
+			}
+			next = nil
+		}
+		return
+	}
+}
+
+// MergeConcurrent returns a lazy Query which yields the elements of all queries,
+// iterating each of them in its own goroutine.
+//
+// The elements are yielded in arrival order, so the order across queries is
+// unspecified and may differ between iterations; only the elements of each single
+// query keep their relative order. The queries must be safe for concurrent iteration.
+//
+// The goroutines block until their elements are consumed. If an iteration stops
+// early, as in First, IsEmpty, Take, FirstWhere, CountAtLeast or ForEachUntil,
+// they stop once the abandoned iterator is garbage collected.
+func MergeConcurrent(queries ...*Query) *Query {
+	iterate := func() Iterator {
+		return mergeConcurrent(queries)
+	}
+	return &Query{Iterate: iterate, src: queries}
+}
+
+// mergeState is only referenced by a mergeConcurrent iterator, so its finalizer
+// tells the goroutines to stop once the iterator is abandoned.
+type mergeState struct {
+	ch   chan T
+	done chan struct{}
+}
+
+func (s *mergeState) stop() {
+	if s.done != nil {
+		close(s.done)
+	}
+}
+
+func mergeConcurrent(queries []*Query) Iterator {
+	s := &mergeState{}
+	runtime.SetFinalizer(s, (*mergeState).stop)
+	return func() (elem T, ok bool) {
+		if s.ch == nil {
+			ch, done := make(chan T, len(queries)), make(chan struct{})
+			s.ch, s.done = ch, done
+			var wg sync.WaitGroup
+			wg.Add(len(queries))
+			for _, q := range queries {
+				go func(q *Query) {
+					defer wg.Done()
+					next := q.Iterate()
+					for e, ok := next(); ok; e, ok = next() {
+						select {
+						case ch <- e:
+						case <-done:
+							return
+						}
+					}
+				}(q)
+			}
+			go func() {
+				wg.Wait()
+				close(ch)
+			}()
+		}
+		elem, ok = <-s.ch
+		return
+	}
+}
+
+// MergeSorted returns a lazy Query which merges this and other, both already
+// sorted by the less function, into one sorted Query.
+//
+// Takes O(n+m) time by repeatedly emitting the smaller of the two head elements.
+// Of equal elements those of this Query come first.
+func (q *Query) MergeSorted(other *Query, less func(a, b T) bool) *Query {
+	iterate := func() Iterator {
+		return mergeSorted(q, other, less)
+	}
+	return &Query{Iterate: iterate, src: []*Query{q, other}}
+}
+
+func mergeSorted(q *Query, other *Query, less func(a, b T) bool) Iterator {
+	nextA, nextB := q.Iterate(), other.Iterate()
+	a, okA := nextA()
+	b, okB := nextB()
+	return func() (elem T, ok bool) {
+		switch {
+		case okA && (!okB || !less(b, a)):
+			elem = a
+			a, okA = nextA()
+			return elem, true
+		case okB:
+			elem = b
+			b, okB = nextB()
+			return elem, true
+		}
+		return
+	}
+}
+
+// MinBy returns the element with the smallest key, or nil if there are no elements.
+//
+// The key of each element is selected once by keySel and compared with less.
+// Of elements with equal keys the first one in iteration order is returned.
+func (q *Query) MinBy(keySel func(e T) interface{}, less func(a, b interface{}) bool) T {
+	return extremeBy(q, keySel, less)
+}
+
+// extremeBy returns the first element whose key is not preceded by any other key.
+func extremeBy(q *Query, keySel func(e T) interface{}, precedes func(a, b interface{}) bool) (result T) {
+	var best interface{}
+	found := false
+	next := q.Iterate()
+	for elem, ok := next(); ok; elem, ok = next() {
+		key := keySel(elem)
+		if !found || precedes(key, best) {
+			result, best, found = elem, key, true
+		}
+	}
+	return
+}
+
+// MinMax returns the smallest and the largest element by the less function
+// in a single pass, or nil and nil if there are no elements.
+//
+// Of equal elements the first one in iteration order is returned.
+func (q *Query) MinMax(less func(a, b T) bool) (min, max T) {
+	next := q.Iterate()
+	min, ok := next()
+	if !ok {
+		return
+	}
+	max = min
+	for elem, ok := next(); ok; elem, ok = next() {
+		if less(elem, min) {
+			min = elem
+		}
+		if less(max, elem) {
+			max = elem
+		}
+	}
+	return
+}
+
+// Mode returns the most frequent element, or nil if there are no elements.
+//
+// Elements are counted like in Tally, so a slice and a string with the same
+// fmt "%v" representation are counted apart. Of equally frequent elements
+// the one which appears first in iteration order is returned.
+func (q *Query) Mode() T {
+	counts := make(map[T]int)
+	var firsts []T
+	next := q.Iterate()
+	for elem, ok := next(); ok; elem, ok = next() {
+		key := tallyKey(elem)
+		if counts[key] == 0 {
+			firsts = append(firsts, elem)
+		}
+		counts[key]++
+	}
+	var mode T
+	max := 0
+	for _, elem := range firsts {
+		if n := counts[tallyKey(elem)]; n > max {
+			mode, max = elem, n
+		}
+	}
+	return mode
+}
+
+// None checks whether no element of this collection satisfies all predicates.
+//
+// Checks every element in iteration order, and returns false
+// as soon as one of them makes all tests return true, otherwise returns true.
+func (q *Query) None(f ...func(e T) bool) bool {
+	return !q.Any(f...)
+}
+
+// Normalize returns a Query with each element scaled linearly to a float64
+// in the range [0, 1], mapping the smallest element to 0 and the largest to 1.
+//
+// Normalize is eager: each iteration materializes all elements first to find
+// their minimum and maximum. If all elements are equal, each is mapped to 0.
+// All elements must be of an integer or floating-point type.
+func (q *Query) Normalize() *Query {
+	iterate := func() Iterator {
+		return normalize(q)
+	}
+	return &Query{Iterate: iterate, src: []*Query{q}}
+}
+
+func normalize(q *Query) Iterator {
+	a := []T{}
+	min, max := math.Inf(1), math.Inf(-1)
+	q.ForEach(func(e T) {
+		var v float64
+		switch r := reflect.ValueOf(e); r.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			v = float64(r.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			v = float64(r.Uint())
+		case reflect.Float32, reflect.Float64:
+			v = r.Float()
+		default:
+			panic(fmt.Sprintf("query: Normalize element %v of type %T is not a number", e, e))
+		}
+		min, max = math.Min(min, v), math.Max(max, v)
+		a = append(a, v)
+	})
+	for i := range a {
+		if max > min {
+			a[i] = (a[i].(float64) - min) / (max - min)
+		} else {
+			a[i] = 0.0
+		}
+	}
+	return from(a)
+}
+
+// OfType returns a new lazy Query with all elements whose dynamic type
+// equals the dynamic type of sample.
+//
+// A nil sample matches only nil elements.
+func (q *Query) OfType(sample T) *Query {
+	t := reflect.TypeOf(sample)
+	iterate := func() Iterator {
+		return where(q, []func(e T) bool{func(e T) bool {
+			return reflect.TypeOf(e) == t
+		}})
+	}
+	return &Query{Iterate: iterate, src: []*Query{q}}
+}
+
+// SortedQuery is an ordering under construction, which is returned by OrderBy.
+// Further keys are added with ThenBy, the sorted Query is returned by Query.
+type SortedQuery struct {
+	q *Query
+	f by
+}
+
+// OrderBy starts an ordering of the elements of this collection by the less function.
+//
+// Secondary keys are added with ThenBy, and Query returns the elements sorted
+// like Sort with all predicates, keeping the original order of equal elements.
+func (q *Query) OrderBy(less func(a, b T) bool) *SortedQuery {
+	return &SortedQuery{q, by{less}}
+}
+
+// ThenBy returns an ordering which sorts elements that are equal by all previous
+// predicates by the less function.
+func (s *SortedQuery) ThenBy(less func(a, b T) bool) *SortedQuery {
+	f := make(by, 0, len(s.f)+1)
+	f = append(f, s.f...)
+	return &SortedQuery{s.q, append(f, less)}
+}
+
+// Query returns a lazy Query with the elements sorted by all predicates.
+func (s *SortedQuery) Query() *Query {
+	return s.q.Sort(s.f...)
+}
+
+// Pad returns a lazy Query which yields the elements of this Query followed by
+// as many fill elements as needed to reach length elements.
+//
+// If this has length or more elements, they are yielded unchanged.
+func (q *Query) Pad(length int, fill T) *Query {
+	iterate := func() Iterator {
+		return pad(q, length, fill)
+	}
+	return &Query{Iterate: iterate, src: []*Query{q}}
+}
+
+func pad(q *Query, length int, fill T) Iterator {
+	next := q.Iterate()
+	i := 0
+	return func() (elem T, ok bool) {
+		if elem, ok = next(); ok {
+			i++
+			return
+		}
+		if i < length {
+			i++
+			return fill, true
+		}
+		return
+	}
+}
+
+// PadLeft returns a Query which yields as many fill elements as needed
+// to reach length elements, followed by the elements of this Query.
+//
+// If this has length or more elements, they are yielded unchanged.
+// Each iteration materializes the elements of this to know its length.
+func (q *Query) PadLeft(length int, fill T) *Query {
+	iterate := func() Iterator {
+		return padLeft(q, length, fill)
+	}
+	return &Query{Iterate: iterate, src: []*Query{q}}
+}
+
+func padLeft(q *Query, length int, fill T) Iterator {
+	a := []T{}
+	q.ForEach(func(e T) {
+		a = append(a, e)
+	})
+	if len(a) >= length {
+		return from(a)
+	}
+	padded := make([]T, length-len(a), length)
+	for k := range padded {
+		padded[k] = fill
+	}
+	return from(append(padded, a...))
+}
+
+// Pairwise returns a lazy Query which emits each pair of adjacent elements as
+// []T{previous, current}.
+//
+// The returned Query has one element less than this, and is empty
+// if this has fewer than two elements.
+func (q *Query) Pairwise() *Query {
+	return q.LagPairs(1)
+}
+
+// Partition splits the elements into those which satisfy the predicate test
+// and the rest, keeping their iteration order.
+//
+// This method is eager: it iterates this Query once and buffers all elements
+// in the returned queries.
+func (q *Query) Partition(f func(e T) bool) (matched *Query, rest *Query) {
+	a, b := []T{}, []T{}
+	next := q.Iterate()
+	for elem, ok := next(); ok; elem, ok = next() {
+		if f(elem) {
+			a = append(a, elem)
+		} else {
+			b = append(b, elem)
+		}
+	}
+	return snapshot(a, q.Err()), snapshot(b, q.Err())
+}
+
+// PartitionByWeight distributes the elements into n partitions of similar total weight.
+//
+// Uses the longest-processing-time-first heuristic: elements are assigned in
+// order of decreasing weight, each to the partition with the smallest total weight so far.
+// Within a partition the elements keep that assignment order.
+//
+// The n must be positive, otherwise nil is returned.
+// This method is eager and materializes all elements.
+func (q *Query) PartitionByWeight(n int, weight func(e T) float64) []*Query {
+	if n <= 0 {
+		return nil
+	}
+	a := ToSlice(q)
+	w := make([]float64, len(a))
+	for i := range a {
+		w[i] = weight(a[i])
+	}
+	idx := make([]int, len(a))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		return w[idx[i]] > w[idx[j]]
+	})
+
+	parts := make([][]T, n)
+	totals := make([]float64, n)
+	for _, i := range idx {
+		min := 0
+		for k := 1; k < n; k++ {
+			if totals[k] < totals[min] {
+				min = k
+			}
+		}
+		parts[min] = append(parts[min], a[i])
+		totals[min] += w[i]
+	}
+
+	result := make([]*Query, n)
+	for k := range parts {
+		result[k] = snapshot(parts[k], q.Err())
+	}
+	return result
+}
+
+// Peeker wraps an Iterator with a one-element lookahead.
+type Peeker struct {
+	next   Iterator
+	elem   T
+	ok     bool
+	peeked bool
+}
+
+// Peekable returns a Peeker over a new iteration of this Query.
+func (q *Query) Peekable() *Peeker {
+	return &Peeker{next: q.Iterate()}
+}
+
+// Peek returns the next element without consuming it.
+// Repeated calls return the same element until Next is called.
+func (p *Peeker) Peek() (elem T, ok bool) {
+	if !p.peeked {
+		p.elem, p.ok = p.next()
+		p.peeked = true
+	}
+	return p.elem, p.ok
+}
+
+// Next returns the next element and advances the iteration.
+func (p *Peeker) Next() (elem T, ok bool) {
+	if p.peeked {
+		p.peeked = false
+		elem, ok = p.elem, p.ok
+		p.elem = nil
+		return
+	}
+	return p.next()
+}
+
+// Percentile returns the element at percentile p by the less function,
+// or nil if there are no elements.
+//
+// Uses the nearest-rank method on the sorted elements: the returned element is
+// the smallest one which is greater than or equal to p percent of all elements.
+// The p is clamped to [0, 100].
+func (q *Query) Percentile(p float64, less func(a, b T) bool) T {
+	a := ToSlice(q)
+	if len(a) == 0 {
+		return nil
+	}
+	by{less}.Sort(a)
+	rank := int(math.Ceil(p / 100 * float64(len(a))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(a) {
+		rank = len(a)
+	}
+	return a[rank-1]
+}
+
+// Prepend returns a lazy Query which yields the element e
+// followed by the elements of this Query.
+func (q *Query) Prepend(e T) *Query {
+	iterate := func() Iterator {
+		return prepend(q, e)
+	}
+	return &Query{Iterate: iterate, src: []*Query{q}}
+}
+
+func prepend(q *Query, e T) Iterator {
+	next := q.Iterate()
+	done := false
+	return func() (elem T, ok bool) {
+		if !done {
+			done = true
+			return e, true
+		}
+		return next()
+	}
+}
+
+// Product returns the product of all elements, or 1 if there are no elements.
+//
+// All elements must be of type int.
+func (q *Query) Product() interface{} {
+	p := 1
+	next := q.Iterate()
+	for elem, ok := next(); ok; elem, ok = next() {
+		p *= elem.(int)
+	}
+	return p
+}
+
+// Reduce reduces a collection to a single value by iteratively combining
+// elements of the collection using the provided function.
+//
+// The iterable must have at least one element.
+// If it has only one element, that element is returned.
+//
+// Otherwise this method starts with the first element from the iterator,
+// and then combines it with the remaining elements in iteration order, as if by:
+//  v := q.First()
+//  q.Skip(1).ForEach(func(e T) {
+//  	v = comb(v, e)
+//  })
+//  return v
+//
+// An empty collection returns nil, use ReduceOK to distinguish it from
+// a reduction to nil.
+func (q *Query) Reduce(f func(v, e T) interface{}) interface{} {
+	v, _ := q.ReduceOK(f)
+	return v
+}
+
+// ReduceOK reduces a collection to a single value like Reduce,
+// and additionally returns false if the collection is empty.
+func (q *Query) ReduceOK(f func(v, e T) interface{}) (interface{}, bool) {
+	next := q.Iterate()
+	if v, ok := next(); ok {
+		for elem, ok := next(); ok; elem, ok = next() {
+			v = f(v, elem)
+		}
+		return v, true
+	}
+	return nil, false
+}
+
+// ReduceRight reduces a collection to a single value like Reduce,
+// but combines the elements from last to first.
+//
+// The iterable must have at least one element.
+// If it has only one element, that element is returned.
+//
+// Otherwise this method starts with the last element and then combines each
+// preceding element with the accumulated value, so for a, b, c it returns
+// f(a, f(b, c)). The elements are materialized to reach the last one first.
+func (q *Query) ReduceRight(f func(e, acc T) interface{}) interface{} {
+	a := ToSlice(q)
+	if len(a) == 0 {
+		return nil
+	}
+	acc := T(a[len(a)-1])
+	for i := len(a) - 2; i >= 0; i-- {
+		acc = f(a[i], acc)
+	}
+	return acc
+}
+
+// RemoveAt returns a lazy Query which yields the elements of this Query
+// except the element at position i.
+//
+// If i is out of range, the elements are yielded unchanged.
+func (q *Query) RemoveAt(i int) *Query {
+	iterate := func() Iterator {
+		return removeAt(q, i)
+	}
+	return &Query{Iterate: iterate, src: []*Query{q}}
+}
+
+func removeAt(q *Query, i int) Iterator {
+	next := q.Iterate()
+	pos := 0
+	return func() (elem T, ok bool) {
+		if elem, ok = next(); ok && pos == i {
+			elem, ok = next()
+		}
+		pos++
+		return
+	}
+}
+
+// Replace returns a lazy Query which yields the elements of this Query
+// with up to limit elements equal to old replaced by repl in iteration order.
+//
+// A limit <= 0 replaces all elements equal to old.
+// Elements are compared like in Contains.
+func (q *Query) Replace(old, repl T, limit int) *Query {
+	iterate := func() Iterator {
+		return replace(q, old, repl, limit)
+	}
+	return &Query{Iterate: iterate, src: []*Query{q}}
+}
+
+func replace(q *Query, old, repl T, limit int) Iterator {
+	next := q.Iterate()
+	left := limit
+	return func() (elem T, ok bool) {
+		if elem, ok = next(); ok && (limit <= 0 || left > 0) && equals(elem, old) {
+			left--
+			return repl, true
+		}
+		return
+	}
+}
+
+// RollingSumInt returns a lazy Query with the sums of all windows of size
+// consecutive int elements.
+//
+// The sum is updated incrementally by subtracting the element leaving the
+// window and adding the one entering it, so the first sum is emitted once
+// the first window is full. If this has fewer than size elements,
+// the resulting Query is empty.
+//
+// The size must be positive and all elements must be of type int.
+func (q *Query) RollingSumInt(size int) *Query {
+	iterate := func() Iterator {
+		return rollingSumInt(q, size)
+	}
+	return &Query{Iterate: iterate, src: []*Query{q}}
+}
+
+func rollingSumInt(q *Query, size int) Iterator {
+	next := q.Iterate()
+	if size <= 0 {
+		return func() (elem T, ok bool) {
+			return
+		}
+	}
+	var ring []int
+	i, sum := 0, 0
+	return func() (elem T, ok bool) {
+		for elem, ok = next(); ok; elem, ok = next() {
+			v, isInt := elem.(int)
+			if !isInt {
+				panic(fmt.Sprintf("query: RollingSumInt element %v of type %T is not an int", elem, elem))
+			}
+			if len(ring) < size {
+				ring = append(ring, v)
+				sum += v
+				if len(ring) < size {
+					continue
+				}
+				return sum, ok
+			}
+			sum += v - ring[i]
+			ring[i] = v
+			i = (i + 1) % size
+			return sum, ok
+		}
+		return
+	}
+}
+
+// Rotate returns a lazy Query with the elements of this Query cyclically
+// shifted left by n positions, so the first n elements move to the end.
+//
+// A negative n shifts right, and n is taken modulo the length.
+// Each iteration materializes the elements of this to know its length.
+func (q *Query) Rotate(n int) *Query {
+	iterate := func() Iterator {
+		return rotate(q, n)
+	}
+	return &Query{Iterate: iterate, src: []*Query{q}}
+}
+
+func rotate(q *Query, n int) Iterator {
+	a := []T{}
+	q.ForEach(func(e T) {
+		a = append(a, e)
+	})
+	if len(a) == 0 {
+		return from(a)
+	}
+	n %= len(a)
+	if n < 0 {
+		n += len(a)
+	}
+	return from(append(a[n:len(a):len(a)], a[:n]...))
+}
+
+// Sample returns a lazy Query of n randomly chosen elements of this Query.
+//
+// Uses reservoir sampling, so the elements are chosen in a single pass
+// without knowing the length up front. The random source is seeded with seed,
+// so every iteration returns the same sample.
+//
+// If this has no more than n elements, all of them are returned in iteration order.
+// A n <= 0 returns an empty Query.
+func (q *Query) Sample(n int, seed int64) *Query {
+	iterate := func() Iterator {
+		return sample(q, n, seed)
 	}
+	return &Query{Iterate: iterate, src: []*Query{q}}
 }
 
-// Last returns the last element.
-func (q *Query) Last() (last T) {
-	next := q.Iterate()
-	for elem, ok := next(); ok; elem, ok = next() {
-		last = elem
+func sample(q *Query, n int, seed int64) Iterator {
+	a := []T{}
+	if n > 0 {
+		r := rand.New(rand.NewSource(seed))
+		i := 0
+		q.ForEach(func(e T) {
+			if i < n {
+				a = append(a, e)
+			} else if j := r.Intn(i + 1); j < n {
+				a[j] = e
+			}
+			i++
+		})
 	}
-	return
+	return from(a)
 }
 
-// MapTo returns a new lazy Query with elements that are created by
-// calling f on each element of this Query in iteration order.
-//
-// This method returns a view of the mapped elements.
-// As long as the returned Iterable is not iterated over,
-// the supplied function f will not be invoked.
-// The transformed elements will not be cached.
-// Iterating multiple times over the returned Iterable will invoke
-// the supplied function f multiple times on the same element.
+// Scan returns a new lazy Query with the running values of a Fold.
 //
-// Methods on the returned query are allowed to omit
-// calling f on any element where the result isn't needed.
-func (q *Query) MapTo(f func(e T) T) *Query {
+// Uses v as the initial value, then iterates through the elements
+// and emits the value updated with each element using the combine function.
+func (q *Query) Scan(v T, f func(v, e T) interface{}) *Query {
 	iterate := func() Iterator {
-		return mapTo(q, f)
+		return scan(q, v, f)
 	}
-	return &Query{iterate}
+	return &Query{Iterate: iterate, src: []*Query{q}}
 }
 
-func mapTo(q *Query, f func(e T) T) Iterator {
+func scan(q *Query, v T, f func(v, e T) interface{}) Iterator {
 	next := q.Iterate()
 	return func() (elem T, ok bool) {
 		elem, ok = next()
 		if ok {
-			return f(elem.(T)), ok
+			v = f(v, elem)
+			return v, ok
 		}
 		return
 	}
 }
 
-// Reduce reduces a collection to a single value by iteratively combining
-// elements of the collection using the provided function.
+// SelectMany expands each element of this Query into zero or more elements like Expand,
+// and projects each of them together with its originating element by resultSel.
+// A nil resultSel yields the expanded elements unchanged.
 //
-// The iterable must have at least one element.
-// If it has only one element, that element is returned.
+// The resulting Query runs through the projected elements
+// for each element of this, in iteration order.
 //
-// Otherwise this method starts with the first element from the iterator,
-// and then combines it with the remaining elements in iteration order, as if by:
-//  v := q.First()
-//  q.Skip(1).ForEach(func(e T) {
-//  	v = comb(v, e)
-//  })
-//  return v
-func (q *Query) Reduce(f func(v, e T) interface{}) interface{} {
-	next := q.Iterate()
-	if v, ok := next(); ok {
-		for elem, ok := next(); ok; elem, ok = next() {
-			v = f(v, elem)
-		}
-		return v
+// The returned Query is lazy, and calls collSel
+// for each element of this every time it's iterated.
+func (q *Query) SelectMany(collSel func(e T) []T, resultSel func(src, item T) interface{}) *Query {
+	iterate := func() Iterator {
+		return expand(q, collSel, resultSel)
 	}
-	return nil
+	return &Query{Iterate: iterate, src: []*Query{q}}
 }
 
 // Skip returns an Query that provides all but the first n elements.
@@ -335,7 +2512,7 @@ func (q *Query) Skip(n int) *Query {
 	iterate := func() Iterator {
 		return skip(q, n)
 	}
-	return &Query{iterate}
+	return &Query{Iterate: iterate, src: []*Query{q}}
 }
 
 func skip(q *Query, n int) Iterator {
@@ -354,6 +2531,43 @@ func skip(q *Query, n int) Iterator {
 	}
 }
 
+// Slice returns a lazy Query of the elements from index start (inclusive)
+// to index stop (exclusive), taking every step-th element.
+//
+// A negative start or stop counts from the end of this Query, like in Python;
+// only then the elements are materialized to determine its length.
+// The step must be positive, otherwise the resulting Query is empty.
+func (q *Query) Slice(start, stop, step int) *Query {
+	iterate := func() Iterator {
+		return slice(q, start, stop, step)
+	}
+	return &Query{Iterate: iterate, src: []*Query{q}}
+}
+
+func slice(q *Query, start, stop, step int) Iterator {
+	src := q
+	if start < 0 || stop < 0 {
+		a := []T{}
+		q.ForEach(func(e T) {
+			a = append(a, e)
+		})
+		if start < 0 {
+			start += len(a)
+		}
+		if stop < 0 {
+			stop += len(a)
+		}
+		if start < 0 {
+			start = 0
+		}
+		src = From(a)
+	}
+	if stop < start {
+		stop = start
+	}
+	return stepBy(src.Skip(start).Take(stop-start), step)
+}
+
 // Sort sorts the elements of a collection in predicate order.
 // Elements are sorted according to a key while keeping
 // the original order of equal elements.
@@ -361,7 +2575,7 @@ func (q *Query) Sort(f ...func(e, f T) bool) *Query {
 	iterate := func() Iterator {
 		return sortBy(q, f)
 	}
-	return &Query{iterate}
+	return &Query{Iterate: iterate, src: []*Query{q}}
 }
 
 func sortBy(q *Query, f []func(e, f T) bool) Iterator {
@@ -379,6 +2593,65 @@ func sortBy(q *Query, f []func(e, f T) bool) Iterator {
 	}
 }
 
+// SortByKey sorts the elements of a collection in key order.
+//
+// The key of each element is selected exactly once by keySel,
+// so it is preferable over Sort for expensive keys.
+// Like Sort, it keeps the original order of equal elements.
+func (q *Query) SortByKey(keySel func(e T) interface{}, less func(a, b interface{}) bool) *Query {
+	iterate := func() Iterator {
+		return sortByKey(q, keySel, less)
+	}
+	return &Query{Iterate: iterate, src: []*Query{q}}
+}
+
+func sortByKey(q *Query, keySel func(e T) interface{}, less func(a, b interface{}) bool) Iterator {
+	a := ToSlice(q)
+	keys := make([]interface{}, len(a))
+	idx := make([]int, len(a))
+	for i := range a {
+		keys[i] = keySel(a[i])
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		return less(keys[idx[i]], keys[idx[j]])
+	})
+
+	i := 0
+	return func() (elem T, ok bool) {
+		ok = i < len(idx)
+		if ok {
+			elem = a[idx[i]]
+			i++
+		}
+		return
+	}
+}
+
+// SortCmp sorts the elements of a collection by a three-way comparator,
+// which returns a negative number if a sorts before b, a positive number
+// if a sorts after b, and zero if they are equal.
+// Like Sort, it keeps the original order of equal elements.
+func (q *Query) SortCmp(cmp func(a, b T) int) *Query {
+	return q.Sort(func(e, f T) bool {
+		return cmp(e, f) < 0
+	})
+}
+
+// SortDescending sorts the elements of a collection in reverse predicate order.
+// Each predicate is inverted, so the natural ascending predicates may be passed.
+// Like Sort, it keeps the original order of equal elements.
+func (q *Query) SortDescending(f ...func(e, f T) bool) *Query {
+	g := make([]func(e, f T) bool, len(f))
+	for k := range f {
+		less := f[k]
+		g[k] = func(e, f T) bool {
+			return less(f, e)
+		}
+	}
+	return q.Sort(g...)
+}
+
 // by is the type of a "less" function array that defines the ordering of its arguments.
 type by []func(e, j T) bool
 
@@ -428,6 +2701,62 @@ func (s *sorter) Less(i, j int) bool {
 	return s.less[k](s.t[i], s.t[j])
 }
 
+// SplitAt returns the first i elements and the remaining elements of this Query.
+//
+// This method is eager: it iterates this Query once and buffers all elements
+// in the returned queries. A negative i is treated as zero.
+func (q *Query) SplitAt(i int) (head *Query, tail *Query) {
+	a, b := []T{}, []T{}
+	q.ForEachIndexed(func(k int, e T) {
+		if k < i {
+			a = append(a, e)
+		} else {
+			b = append(b, e)
+		}
+	})
+	return snapshot(a, q.Err()), snapshot(b, q.Err())
+}
+
+// StepBy returns a lazy Query of every nth element of this Query,
+// starting with the first one (so indices 0, n, 2n, ...).
+//
+// The n must be positive, otherwise the resulting Query is empty.
+func (q *Query) StepBy(n int) *Query {
+	iterate := func() Iterator {
+		return stepBy(q, n)
+	}
+	return &Query{Iterate: iterate, src: []*Query{q}}
+}
+
+func stepBy(q *Query, n int) Iterator {
+	next := q.Iterate()
+	i := 0
+	return func() (elem T, ok bool) {
+		if n <= 0 {
+			return
+		}
+		for elem, ok = next(); ok; elem, ok = next() {
+			has := i%n == 0
+			i++
+			if has {
+				return
+			}
+		}
+		return
+	}
+}
+
+// SumBy returns the sum of the values that sel selects from each element,
+// or 0 if there are no elements.
+func (q *Query) SumBy(sel func(e T) int) int {
+	s := 0
+	next := q.Iterate()
+	for elem, ok := next(); ok; elem, ok = next() {
+		s += sel(elem)
+	}
+	return s
+}
+
 // Take returns a lazy query of the n first elements of this query.
 //
 // The returned Query may contain fewer than n elements,
@@ -441,7 +2770,7 @@ func (q *Query) Take(n int) *Query {
 	iterate := func() Iterator {
 		return take(q, n)
 	}
-	return &Query{iterate}
+	return &Query{Iterate: iterate, src: []*Query{q}}
 }
 
 func take(q *Query, n int) Iterator {
@@ -455,17 +2784,279 @@ func take(q *Query, n int) Iterator {
 	}
 }
 
+// TakeLast returns a lazy query of the n last elements of this query
+// in iteration order.
+//
+// The returned Query contains all elements if this contains fewer than n elements,
+// and is empty if n <= 0.
+//
+// The elements are computed by stepping through the whole iterator
+// while keeping the last n elements seen in a ring buffer.
+func (q *Query) TakeLast(n int) *Query {
+	iterate := func() Iterator {
+		return takeLast(q, n)
+	}
+	return &Query{Iterate: iterate, src: []*Query{q}}
+}
+
+func takeLast(q *Query, n int) Iterator {
+	if n <= 0 {
+		return from(nil)
+	}
+	// The ring grows with the elements seen, so a large n does not allocate up front.
+	var ring []T
+	start := 0
+	q.ForEach(func(e T) {
+		if len(ring) < n {
+			ring = append(ring, e)
+			return
+		}
+		ring[start] = e
+		start = (start + 1) % n
+	})
+	i := 0
+	return func() (elem T, ok bool) {
+		ok = i < len(ring)
+		if ok {
+			elem = ring[(start+i)%len(ring)]
+			i++
+		}
+		return
+	}
+}
+
+// Tally returns the number of occurrences of each distinct element.
+//
+// Elements of comparable types are counted by ==. Elements of non-comparable
+// types like slices cannot be map keys, so they are counted by their
+// fmt "%v" representation instead. That key has an unexported string type,
+// so it never collides with an equal string element.
+//
+// An empty Query returns an empty map.
+func (q *Query) Tally() map[T]int {
+	result := make(map[T]int)
+	next := q.Iterate()
+	for elem, ok := next(); ok; elem, ok = next() {
+		result[tallyKey(elem)]++
+	}
+	return result
+}
+
+// fmtKey is the map key of an element which cannot be used as a map key itself.
+type fmtKey string
+
+// tallyKey returns e if it can be used as a map key,
+// otherwise its fmt "%v" representation as a fmtKey.
+func tallyKey(e T) T {
+	if !isComparable(reflect.ValueOf(e)) {
+		return fmtKey(fmt.Sprintf("%v", e))
+	}
+	return e
+}
+
+// Tap returns a new lazy Query which calls f on each element of this Query
+// as it passes through, leaving the elements unchanged.
+//
+// Like MapTo, f is called once per element for every iteration.
+func (q *Query) Tap(f func(e T)) *Query {
+	iterate := func() Iterator {
+		return tap(q, f)
+	}
+	return &Query{Iterate: iterate, src: []*Query{q}}
+}
+
+func tap(q *Query, f func(e T)) Iterator {
+	next := q.Iterate()
+	return func() (elem T, ok bool) {
+		elem, ok = next()
+		if ok {
+			f(elem)
+		}
+		return
+	}
+}
+
+// Tee returns two queries which both yield the elements of this Query.
+//
+// This method is eager: it iterates this Query once, so upstream side effects
+// are not repeated, and both returned queries share the buffered elements.
+func (q *Query) Tee() (*Query, *Query) {
+	a := []T{}
+	q.ForEach(func(e T) {
+		a = append(a, e)
+	})
+	return snapshot(a, q.Err()), snapshot(a, q.Err())
+}
+
 // ToSlice iterates over a collection and saves the results in the slice pointed
 // by v. It overwrites the existing slice, starting from index 0.
 func ToSlice(q *Query) []interface{} {
-	a := []interface{}{}
+	return q.AppendTo([]interface{}{})
+}
+
+// ToIntSlice materializes the elements of this collection into a []int.
+//
+// Returns an error identifying the first element which is not of type int.
+func (q *Query) ToIntSlice() ([]int, error) {
+	a := []int{}
+	i := 0
 	next := q.Iterate()
 	for elem, ok := next(); ok; elem, ok = next() {
-		a = append(a, elem)
+		v, is := elem.(int)
+		if !is {
+			return nil, fmt.Errorf("query: element %d is %T, not int", i, elem)
+		}
+		a = append(a, v)
+		i++
+	}
+	return a, nil
+}
+
+// ToStringSlice materializes the elements of this collection into a []string.
+//
+// Returns an error identifying the first element which is not of type string.
+func (q *Query) ToStringSlice() ([]string, error) {
+	a := []string{}
+	i := 0
+	next := q.Iterate()
+	for elem, ok := next(); ok; elem, ok = next() {
+		v, is := elem.(string)
+		if !is {
+			return nil, fmt.Errorf("query: element %d is %T, not string", i, elem)
+		}
+		a = append(a, v)
+		i++
+	}
+	return a, nil
+}
+
+// TopN returns a lazy query of the n largest elements by the less function
+// in descending order.
+//
+// The elements are selected in a single pass with a bounded heap of size n,
+// which takes O(m log n) time for m elements instead of sorting all of them.
+// The returned Query contains all elements if this contains fewer than n elements,
+// and is empty if n <= 0.
+func (q *Query) TopN(n int, less func(a, b T) bool) *Query {
+	iterate := func() Iterator {
+		return from(selectN(q, n, less))
+	}
+	return &Query{Iterate: iterate, src: []*Query{q}}
+}
+
+// selectN returns the n largest elements by the less function in descending order.
+func selectN(q *Query, n int, less func(a, b T) bool) []T {
+	if n <= 0 {
+		return nil
+	}
+	h := largestN(q, n, less)
+	a := make([]T, h.Len())
+	for i := len(a) - 1; i >= 0; i-- {
+		a[i] = heap.Pop(h)
 	}
 	return a
 }
 
+// largestN returns a bounded heap of the n largest elements by the less function,
+// with the smallest of them at its root.
+func largestN(q *Query, n int, less func(a, b T) bool) *elemHeap {
+	h := &elemHeap{less: less}
+	q.ForEach(func(e T) {
+		if h.Len() < n {
+			heap.Push(h, e)
+		} else if less(h.a[0], e) {
+			h.a[0] = e
+			heap.Fix(h, 0)
+		}
+	})
+	return h
+}
+
+// elemHeap is a heap of elements with the smallest element by less at its root.
+type elemHeap struct {
+	a    []T
+	less func(a, b T) bool
+}
+
+// Len is part of heap.Interface.
+func (h *elemHeap) Len() int {
+	return len(h.a)
+}
+
+// Less is part of heap.Interface.
+func (h *elemHeap) Less(i, j int) bool {
+	return h.less(h.a[i], h.a[j])
+}
+
+// Swap is part of heap.Interface.
+func (h *elemHeap) Swap(i, j int) {
+	h.a[i], h.a[j] = h.a[j], h.a[i]
+}
+
+// Push is part of heap.Interface.
+func (h *elemHeap) Push(e interface{}) {
+	h.a = append(h.a, e)
+}
+
+// Pop is part of heap.Interface.
+func (h *elemHeap) Pop() interface{} {
+	e := h.a[len(h.a)-1]
+	h.a = h.a[:len(h.a)-1]
+	return e
+}
+
+// TryMapTo returns a new lazy Query with elements that are created by
+// calling the fallible function f on each element of this Query in iteration order.
+//
+// The first error returned by f ends the iteration: the failing element is not
+// yielded, the iterator halts and the error is returned by Err of the returned Query,
+// and of all queries derived from it, until it is iterated again.
+func (q *Query) TryMapTo(f func(e T) (T, error)) *Query {
+	r := &Query{src: []*Query{q}}
+	r.Iterate = func() Iterator {
+		return tryMapTo(q, r, f)
+	}
+	return r
+}
+
+func tryMapTo(q *Query, r *Query, f func(e T) (T, error)) Iterator {
+	next := q.Iterate()
+	r.setErr(nil)
+	failed := false
+	return func() (elem T, ok bool) {
+		if failed {
+			return
+		}
+		if elem, ok = next(); !ok {
+			return
+		}
+		var err error
+		if elem, err = f(elem); err != nil {
+			r.setErr(err)
+			failed = true
+			return nil, false
+		}
+		return
+	}
+}
+
+// WeightedAverage returns sum(value*weight)/sum(weight) of the values and weights
+// that valueSel and weightSel select from each element,
+// or 0 if the total weight is 0.
+func (q *Query) WeightedAverage(valueSel func(e T) float64, weightSel func(e T) float64) float64 {
+	s, w := 0.0, 0.0
+	next := q.Iterate()
+	for elem, ok := next(); ok; elem, ok = next() {
+		weight := weightSel(elem)
+		s += valueSel(elem) * weight
+		w += weight
+	}
+	if w == 0 {
+		return 0
+	}
+	return s / w
+}
+
 // Where returns a new lazy Query with all elements that satisfy all predicate tests.
 //
 // The matching elements have the same order in the returned iterable as they have in iterator.
@@ -474,11 +3065,13 @@ func ToSlice(q *Query) []interface{} {
 // is not iterated over, the supplied function test will not be invoked.
 // Iterating will not cache results, and thus iterating multiple times over the returned
 // Query may invoke the supplied function test multiple times on the same element.
+//
+// Nil predicates are skipped, as if they always returned true.
 func (q *Query) Where(f ...func(e T) bool) *Query {
 	iterate := func() Iterator {
 		return where(q, f)
 	}
-	return &Query{iterate}
+	return &Query{Iterate: iterate, src: []*Query{q}}
 }
 
 // where returns a new lazy iterator with all elements that satisfy all predicate tests.
@@ -486,10 +3079,33 @@ func where(q *Query, f []func(e T) bool) Iterator {
 	next := q.Iterate()
 	return func() (elem T, ok bool) {
 		for elem, ok = next(); ok; elem, ok = next() {
-			has := true
-			for k := 0; k < len(f); k++ {
-				has = has && f[k](elem)
+			if matches(f, elem) {
+				return
 			}
+		}
+		return
+	}
+}
+
+// WhereIndexed returns a new lazy Query with all elements that satisfy the predicate test,
+// which is passed the zero-based index of each element.
+//
+// Like Where, the matching elements keep their iteration order and
+// the index restarts at zero for every iteration.
+func (q *Query) WhereIndexed(f func(i int, e T) bool) *Query {
+	iterate := func() Iterator {
+		return whereIndexed(q, f)
+	}
+	return &Query{Iterate: iterate, src: []*Query{q}}
+}
+
+func whereIndexed(q *Query, f func(i int, e T) bool) Iterator {
+	next := q.Iterate()
+	i := 0
+	return func() (elem T, ok bool) {
+		for elem, ok = next(); ok; elem, ok = next() {
+			has := f(i, elem)
+			i++
 			if has {
 				return
 			}
@@ -497,3 +3113,62 @@ func where(q *Query, f []func(e T) bool) Iterator {
 		return
 	}
 }
+
+// matches reports whether e satisfies all predicates, skipping nil ones.
+func matches(f []func(e T) bool, e T) bool {
+	for k := 0; k < len(f); k++ {
+		if f[k] != nil && !f[k](e) {
+			return false
+		}
+	}
+	return true
+}
+
+// WhereNot returns a new lazy Query with all elements that fail at least one
+// of the predicate tests, which are exactly those Where would drop.
+//
+// The remaining elements keep their iteration order.
+// Nil predicates are skipped, as if they always returned true.
+func (q *Query) WhereNot(f ...func(e T) bool) *Query {
+	iterate := func() Iterator {
+		return where(q, []func(e T) bool{func(e T) bool {
+			return !matches(f, e)
+		}})
+	}
+	return &Query{Iterate: iterate, src: []*Query{q}}
+}
+
+// Position is the element type of the Query returned by WithPosition.
+// It holds an element and whether it is the first or last one.
+type Position struct {
+	First, Last bool
+	Value       T
+}
+
+// WithPosition returns a lazy Query which annotates each element with its position.
+//
+// First is true only for the initial element and Last only for the final one,
+// so a single element has both set. To know the final element the iterator
+// looks ahead by one element.
+func (q *Query) WithPosition() *Query {
+	iterate := func() Iterator {
+		return withPosition(q)
+	}
+	return &Query{Iterate: iterate, src: []*Query{q}}
+}
+
+func withPosition(q *Query) Iterator {
+	next := q.Iterate()
+	cur, has := next()
+	first := true
+	return func() (elem T, ok bool) {
+		if !has {
+			return
+		}
+		elem = cur
+		cur, has = next()
+		p := Position{first, !has, elem}
+		first = false
+		return p, true
+	}
+}
@@ -8,10 +8,21 @@
 package query
 
 import (
+	"bufio"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"math/rand"
 	"reflect"
+	"runtime"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"testing/iotest"
 	"time"
 )
 
@@ -317,6 +328,40 @@ func Test_truth(t *testing.T) {
 	}
 }
 
+func TestQuery_Aggregate(t *testing.T) {
+	double := func(v T) interface{} {
+		return v.(int) * 2
+	}
+	format := func(v T) interface{} {
+		return fmt.Sprintf("sum=%v", v)
+	}
+
+	type args struct {
+		v         T
+		f         func(t1, t2 T) interface{}
+		resultSel func(v T) interface{}
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want interface{}
+	}{
+		{"aggregate#1", From([]T{}), args{0, sum, double}, 0},
+		{"aggregate#2", From([]T{}), args{10, sum, double}, 20},
+		{"aggregate#3", From(span(1, 9)), args{0, sum, double}, 90},
+		{"aggregate#4", From(span(1, 9)), args{10, sum, double}, 110},
+		{"aggregate#5", From(span(1, 9)), args{0, sum, format}, "sum=45"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.Aggregate(tt.args.v, tt.args.f, tt.args.resultSel); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Query.Aggregate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestQuery_Any(t *testing.T) {
 	type args struct {
 		f []func(T) bool
@@ -336,6 +381,8 @@ func TestQuery_Any(t *testing.T) {
 		{"any#7", From(span(1, 9)), args{[]func(T) bool{truth(false), truth(true)}}, false},
 		{"any#8", From(span(1, 9)), args{[]func(T) bool{truth(true), truth(true)}}, true},
 		{"any#9", From(span(1, 9)), args{[]func(T) bool{truth(true), truth(false)}}, false},
+		{"any#10", From(span(1, 9)), args{[]func(T) bool{nil, truth(true)}}, true},
+		{"any#11", From(span(1, 9)), args{[]func(T) bool{nil, truth(false)}}, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -346,167 +393,190 @@ func TestQuery_Any(t *testing.T) {
 	}
 }
 
-func TestQuery_At(t *testing.T) {
+func TestQuery_Append(t *testing.T) {
 	type args struct {
-		i int
+		e T
 	}
 	tests := []struct {
 		name string
 		q    *Query
 		args args
-		want T
+		want *Query
 	}{
-		{"at#1", From([]T{}), args{0}, nil},
-		{"at#2", From(span(1, 9)), args{5}, 6},
-		{"at#3", From(span(1, 9)), args{15}, nil},
-		{"at#4", From(span(1, 9)), args{-100}, nil},
+		{"append#1", From([]T{}), args{}, From([]T{nil})},
+		{"append#2", From([]T{}), args{4}, From([]T{4})},
+		{"append#3", From(span(1, 3)), args{4}, From(span(1, 4))},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := tt.q.At(tt.args.i); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("Query.At() = %v, want %v", got, tt.want)
+			if got := tt.q.Append(tt.args.e); !got.equal(tt.want) {
+				t.Errorf("Query.Append() = %v, want %v", got, tt.want)
+			}
+			if got := tt.q.Append(tt.args.e); !got.equal(got) {
+				t.Errorf("Query.Append() = %v, not re-iterable", got)
 			}
 		})
 	}
 }
 
-func TestQuery_Contains(t *testing.T) {
+func TestQuery_AppendTo(t *testing.T) {
 	type args struct {
-		t T
+		dst []interface{}
 	}
 	tests := []struct {
 		name string
 		q    *Query
 		args args
-		want bool
+		want []interface{}
 	}{
-		{"contains#1", From([]T{}), args{}, false},
-		{"contains#2", From([]T{}), args{42}, false},
-		{"contains#3", From(span(1, 9)), args{}, false},
-		{"contains#4", From(span(1, 9)), args{5}, true},
-		{"contains#5", From(span(1, 9)), args{10}, false},
+		{"appendto#1", From([]T{}), args{nil}, nil},
+		{"appendto#2", From([]T{}), args{[]interface{}{0}}, []interface{}{0}},
+		{"appendto#3", From(span(1, 3)), args{nil}, []interface{}{1, 2, 3}},
+		{"appendto#4", From(span(1, 3)), args{[]interface{}{0}}, []interface{}{0, 1, 2, 3}},
+		{"appendto#5", From(span(1, 3)), args{make([]interface{}, 0, 8)}, []interface{}{1, 2, 3}},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := tt.q.Contains(tt.args.t); got != tt.want {
-				t.Errorf("Query.Contains() = %v, want %v", got, tt.want)
+			if got := tt.q.AppendTo(tt.args.dst); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Query.AppendTo() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
-func TestQuery_Empty(t *testing.T) {
-	tests := []struct {
-		name string
-		q    *Query
-		want bool
-	}{
-		{"empty#1", From([]T{}), true},
-		{"empty#2", From([]T{1}), false},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if got := tt.q.IsEmpty(); got != tt.want {
-				t.Errorf("Query.Empty() = %v, want %v", got, tt.want)
-			}
-		})
+func TestQuery_AppendTo_reuse(t *testing.T) {
+	buf := make([]interface{}, 0, 16)
+	got := From(span(1, 9)).AppendTo(buf)
+	if &got[0] != &buf[:1][0] {
+		t.Errorf("Query.AppendTo() did not reuse the capacity of dst")
 	}
 }
 
-func TestQuery_Every(t *testing.T) {
+func TestQuery_At(t *testing.T) {
 	type args struct {
-		f []func(T) bool
+		i int
 	}
 	tests := []struct {
 		name string
 		q    *Query
 		args args
-		want bool
+		want T
 	}{
-		{"every#1", From([]T{}), args{}, true},
-		{"every#2", From([]T{}), args{[]func(T) bool{truth(true), truth(true)}}, true},
-		{"every#3", From([]T{}), args{[]func(T) bool{truth(false), truth(false)}}, true},
-		{"every#4", From(span(1, 9)), args{[]func(T) bool{truth(false), truth(false)}}, false},
-		{"every#5", From(span(1, 9)), args{[]func(T) bool{truth(true), truth(true)}}, true},
+		{"at#1", From([]T{}), args{0}, nil},
+		{"at#2", From(span(1, 9)), args{5}, 6},
+		{"at#3", From(span(1, 9)), args{15}, nil},
+		{"at#4", From(span(1, 9)), args{-100}, nil},
+		{"at#5", From(span(1, 9)), args{8}, 9},
+		{"at#6", From(span(1, 9)), args{9}, nil},
+		{"at#7", From(span(1, 9)), args{10}, nil},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := tt.q.Every(tt.args.f...); got != tt.want {
-				t.Errorf("Query.Every() = %v, want %v", got, tt.want)
+			if got := tt.q.At(tt.args.i); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Query.At() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
-func TestQuery_First(t *testing.T) {
+func Test_at(t *testing.T) {
+	type args struct {
+		q *Query
+		i int
+	}
 	tests := []struct {
-		name string
-		q    *Query
-		want T
+		name   string
+		args   args
+		want   T
+		wantOk bool
 	}{
-		{"first#1", From([]T{}), nil},
-		{"first#2", From([]T{1}), 1},
-		{"first#3", From(span(1, 9)), 1},
+		{"at#1", args{From([]T{}), 0}, nil, false},
+		{"at#2", args{From(span(1, 9)), 0}, 1, true},
+		{"at#3", args{From(span(1, 9)), 8}, 9, true},
+		{"at#4", args{From(span(1, 9)), 9}, nil, false},
+		{"at#5", args{From(span(1, 9)), 10}, nil, false},
+		{"at#6", args{From(span(1, 9)), -1}, nil, false},
+		{"at#7", args{From([]T{nil}), 0}, nil, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := tt.q.First(); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("Query.First() = %v, want %v", got, tt.want)
+			got, ok := at(tt.args.q, tt.args.i)
+			if !reflect.DeepEqual(got, tt.want) || ok != tt.wantOk {
+				t.Errorf("at() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
 			}
 		})
 	}
 }
 
-func TestQuery_Fold(t *testing.T) {
+func TestQuery_AverageBy(t *testing.T) {
+	books := []T{
+		Book{1, "Sense & Sensibility", 1811},
+		Book{2, "Pride & Prejudice", 1813},
+		Book{4, "Emma", 1815},
+		Book{5, "Persuasion", 1817},
+	}
+	year := func(e T) float64 {
+		return float64(e.(Book).Year)
+	}
+	identity := func(e T) float64 {
+		return float64(e.(int))
+	}
 	type args struct {
-		v T
-		f func(t1, t2 T) interface{}
+		sel func(e T) float64
 	}
 	tests := []struct {
 		name string
 		q    *Query
 		args args
-		want interface{}
+		want float64
 	}{
-		{"fold#1", From([]T{}), args{}, nil},
-		{"fold#2", From([]T{}), args{0, sum}, 0},
-		{"fold#3", From([]T{}), args{10, sum}, 10},
-		{"fold#4", From([]T{}), args{-10, sum}, -10},
-		{"fold#5", From(span(1, 9)), args{0, sum}, 45},
-		{"fold#6", From(span(1, 9)), args{10, sum}, 55},
-		{"fold#7", From(span(1, 9)), args{-10, sum}, 35},
+		{"averageby#1", From([]T{}), args{year}, 0},
+		{"averageby#2", From(books), args{year}, 1814},
+		{"averageby#3", From(span(1, 4)), args{identity}, 2.5},
+		{"averageby#4", From([]T{-1, 1}), args{identity}, 0},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := tt.q.Fold(tt.args.v, tt.args.f); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("Query.Fold() = %v, want %v", got, tt.want)
+			if got := tt.q.AverageBy(tt.args.sel); got != tt.want {
+				t.Errorf("Query.AverageBy() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
-func TestQuery_ForEach(t *testing.T) {
+func TestQuery_Batch(t *testing.T) {
 	type args struct {
-		f func(T)
+		size int
 	}
 	tests := []struct {
 		name string
 		q    *Query
 		args args
+		want [][]T
 	}{
-		{"foreach#1", From([]T{}), args{}},
-		{"foreach#2", From(span(1, 9)), args{print}},
+		{"batch#1", From([]T{}), args{3}, nil},
+		{"batch#2", From(span(1, 10)), args{3}, [][]T{{1, 2, 3}, {4, 5, 6}, {7, 8, 9}, {10}}},
+		{"batch#3", From(span(1, 9)), args{3}, [][]T{{1, 2, 3}, {4, 5, 6}, {7, 8, 9}}},
+		{"batch#4", From(span(1, 3)), args{10}, [][]T{{1, 2, 3}}},
+		{"batch#5", From(span(1, 3)), args{0}, [][]T{{1}, {2}, {3}}},
+		{"batch#6", From(span(1, 3)), args{math.MaxInt64}, [][]T{{1, 2, 3}}},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			tt.q.ForEach(tt.args.f)
+			var got [][]T
+			tt.q.Batch(tt.args.size, func(batch []T) {
+				got = append(got, batch)
+			})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Query.Batch() = %v, want %v", got, tt.want)
+			}
 		})
 	}
 }
 
-func TestQuery_Expand(t *testing.T) {
+func TestQuery_BottomN(t *testing.T) {
 	type args struct {
-		f func(e T) []T
+		n int
 	}
 	tests := []struct {
 		name string
@@ -514,56 +584,64 @@ func TestQuery_Expand(t *testing.T) {
 		args args
 		want *Query
 	}{
-		{"expand#1", From([]T{}), args{null}, From([]T{})},
-		{"expand#2", From([]T{1, 2, 3}), args{null}, From([]T{})},
-		{"expand#3", From([]T{}), args{copy}, From([]T{})},
-		{"expand#4", From([]T{1, 2, 3}), args{copy}, From([]T{1, 2, 3})},
-		{"expand#5", From([]T{}), args{duplicate}, From([]T{})},
-		{"expand#6", From([]T{1, 2, 3}), args{duplicate}, From([]T{1, 1, 2, 2, 3, 3})},
+		{"bottomn#1", From([]T{}), args{3}, From([]T{})},
+		{"bottomn#2", From(shuffle(span(1, 100))), args{3}, From([]T{1, 2, 3})},
+		{"bottomn#3", From(shuffle(span(1, 9))), args{9}, From(span(1, 9))},
+		{"bottomn#4", From(shuffle(span(1, 9))), args{100}, From(span(1, 9))},
+		{"bottomn#5", From(shuffle(span(1, 9))), args{0}, From([]T{})},
+		{"bottomn#6", From(shuffle(span(1, 9))), args{-1}, From([]T{})},
+		{"bottomn#7", From([]T{1, 3, 1, 2, 1}), args{2}, From([]T{1, 1})},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := tt.q.Expand(tt.args.f); !got.equal(tt.want) {
-				t.Errorf("Query.Expand() = %v, want %v", got, tt.want)
+			if got := tt.q.BottomN(tt.args.n, less); !got.equal(tt.want) {
+				t.Errorf("Query.BottomN() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
-func TestFrom(t *testing.T) {
-	type args struct {
-		t []T
-	}
+func TestQuery_Cache(t *testing.T) {
 	tests := []struct {
 		name string
-		args args
+		q    *Query
 		want *Query
 	}{
-		{"from#1", args{[]T{}}, From([]T{})},
-		{"from#2", args{span(1, 9)}, From(span(1, 9))},
+		{"cache#1", From([]T{}), From([]T{})},
+		{"cache#2", From([]T{1}), From([]T{1})},
+		{"cache#3", From(span(1, 9)), From(span(1, 9))},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := From(tt.args.t); !got.equal(tt.want) {
-				t.Errorf("From() = %v, want %v", got, tt.want)
+			n := 0
+			got := tt.q.
+				MapTo(func(e T) T {
+					n++
+					return e
+				}).
+				Cache()
+			for i := 0; i < 2; i++ {
+				if !got.equal(tt.want) {
+					t.Errorf("Query.Cache() = %v, want %v", got, tt.want)
+				}
+			}
+			if want := len(ToSlice(tt.want)); n != want {
+				t.Errorf("Query.Cache() mapped %v elements, want %v", n, want)
 			}
 		})
 	}
 }
 
-func TestQuery_Join(t *testing.T) {
-	keySel := func(e T) interface{} {
-		return e
+func TestQuery_ChunkBy(t *testing.T) {
+	changed := func(prev, curr T) bool {
+		return prev != curr
 	}
-	resultSel := func(o, i interface{}) interface{} {
-		return o
+	descending := func(prev, curr T) bool {
+		return curr.(int) < prev.(int)
 	}
 
 	type args struct {
-		inner     *Query
-		outKeySel func(T) interface{}
-		innKeySel func(T) interface{}
-		resultSel func(o, i interface{}) interface{}
+		boundary func(prev, curr T) bool
 	}
 	tests := []struct {
 		name string
@@ -571,188 +649,3316 @@ func TestQuery_Join(t *testing.T) {
 		args args
 		want *Query
 	}{
-		{"join#1", From([]T{}), args{From([]T{}), nil, nil, nil}, From([]T{})},
-		{"join#2", From([]T{}), args{From([]T{}), keySel, keySel, resultSel}, From([]T{})},
-		{"join#3", From(span(1, 9)), args{From([]T{}), keySel, keySel, resultSel}, From([]T{})},
-		{"join#4", From(span(4, 9)), args{From(span(1, 6)), keySel, keySel, resultSel}, From(span(4, 6))},
-		{"join#5", From(span(1, 6)), args{From(span(6, 9)), keySel, keySel, resultSel}, From([]T{6})},
-		{"join#6", From(span(1, 5)), args{From(span(6, 9)), keySel, keySel, resultSel}, From([]T{})},
-		{"join#7", From([]T{}), args{From(span(6, 9)), keySel, keySel, resultSel}, From([]T{})},
+		{"chunkby#1", From([]T{}), args{changed}, From([]T{})},
+		{"chunkby#2", From([]T{1}), args{changed}, From([]T{[]T{1}})},
+		{"chunkby#3", From([]T{1, 2, 2, 3, 1}), args{changed}, From([]T{[]T{1}, []T{2, 2}, []T{3}, []T{1}})},
+		{"chunkby#4", From([]T{1, 2, 5, 3, 4, 1}), args{descending}, From([]T{[]T{1, 2, 5}, []T{3, 4}, []T{1}})},
+		{"chunkby#5", From(span(1, 3)), args{func(prev, curr T) bool { return false }}, From([]T{[]T{1, 2, 3}})},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := tt.q.Join(tt.args.inner, tt.args.outKeySel, tt.args.innKeySel, tt.args.resultSel); !got.equal(tt.want) {
-				t.Errorf("Query.Join() = %v, want %v", got, tt.want)
+			if got := tt.q.ChunkBy(tt.args.boundary); !got.equal(tt.want) {
+				t.Errorf("Query.ChunkBy() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
-func TestQuery_Last(t *testing.T) {
+func TestQuery_Compact(t *testing.T) {
 	tests := []struct {
 		name string
 		q    *Query
-		want T
+		want *Query
 	}{
-		{"last#1", From([]T{}), nil},
-		{"last#2", From([]T{1}), 1},
-		{"last#3", From(span(1, 9)), 9},
+		{"compact#1", From([]T{}), From([]T{})},
+		{"compact#2", From([]T{nil, nil}), From([]T{})},
+		{"compact#3", From(span(1, 3)), From(span(1, 3))},
+		{"compact#4", From([]T{1, nil, 2, nil, 3}), From(span(1, 3))},
+		{"compact#5", From([]T{(*int)(nil)}), From([]T{(*int)(nil)})},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := tt.q.Last(); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("Query.Last() = %v, want %v", got, tt.want)
+			if got := tt.q.Compact(); !got.equal(tt.want) {
+				t.Errorf("Query.Compact() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
-func TestQuery_MapTo(t *testing.T) {
+// edition is an Equaler which considers only the ISBN of a book.
+type edition struct {
+	ISBN  string
+	Title string
+}
+
+func (e edition) Equals(other T) bool {
+	o, ok := other.(edition)
+	return ok && e.ISBN == o.ISBN
+}
+
+func TestQuery_Contains(t *testing.T) {
 	type args struct {
-		f func(e T) T
+		t T
 	}
 	tests := []struct {
 		name string
 		q    *Query
 		args args
-		want *Query
+		want bool
 	}{
-		{"mapto#1", From([]T{}), args{}, From([]T{})},
-		{"mapto#2", From([]T{}), args{func(e T) T { return e.(int) + 10 }}, From([]T{})},
-		{"mapto#3", From([]T{1, 2, 3, 4, 5}), args{func(e T) T { return e.(int) + 10 }}, From([]T{11, 12, 13, 14, 15})},
+		{"contains#1", From([]T{}), args{}, false},
+		{"contains#2", From([]T{}), args{42}, false},
+		{"contains#3", From(span(1, 9)), args{}, false},
+		{"contains#4", From(span(1, 9)), args{5}, true},
+		{"contains#5", From(span(1, 9)), args{10}, false},
+		{"contains#6", From([]T{[]T{1, 2}, []T{3}}), args{[]T{3}}, true},
+		{"contains#7", From([]T{[]T{1, 2}, []T{3}}), args{[]T{2}}, false},
+		{"contains#8", From([]T{[]T{1, 2}, 3}), args{3}, true},
+		{"contains#9", From([]T{[]T{1, 2}, nil}), args{}, true},
+		{"contains#10", From([]T{edition{"0-14-143951-3", "Emma"}}), args{edition{"0-14-143951-3", "Emma (Penguin)"}}, true},
+		{"contains#11", From([]T{edition{"0-14-143951-3", "Emma"}}), args{edition{"0-14-143967-X", "Emma"}}, false},
+		{"contains#12", From([]T{1, edition{"0-14-143951-3", "Emma"}}), args{"0-14-143951-3"}, false},
+		{"contains#13", From([]T{Position{true, true, []T{1}}}), args{Position{true, true, []T{1}}}, true},
+		{"contains#14", From([]T{Position{true, true, []T{1}}}), args{Position{true, true, []T{2}}}, false},
+		{"contains#15", From([]T{[]T{1}}).WithPosition(), args{Position{true, true, []T{1}}}, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := tt.q.MapTo(tt.args.f); !got.equal(tt.want) {
-				t.Errorf("Query.MapTo() = %v, want %v", got, tt.want)
+			if got := tt.q.Contains(tt.args.t); got != tt.want {
+				t.Errorf("Query.Contains() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
-func TestQuery_Reduce(t *testing.T) {
+func TestQuery_ContainsFunc(t *testing.T) {
+	sameParity := func(a, b T) bool {
+		return a.(int)%2 == b.(int)%2
+	}
+	deepEqual := func(a, b T) bool {
+		return reflect.DeepEqual(a, b)
+	}
+
 	type args struct {
-		f func(v T, e T) interface{}
+		e  T
+		eq func(a, b T) bool
 	}
 	tests := []struct {
 		name string
 		q    *Query
 		args args
-		want interface{}
+		want bool
 	}{
-		{"reduce#1", From([]T{}), args{}, nil},
-		{"reduce#2", From([]T{}), args{sum}, nil},
-		{"reduce#3", From([]T{1}), args{sum}, 1},
-		{"reduce#4", From(span(1, 9)), args{sum}, 45},
+		{"containsfunc#1", From([]T{}), args{1, sameParity}, false},
+		{"containsfunc#2", From([]T{1, 3, 5}), args{7, sameParity}, true},
+		{"containsfunc#3", From([]T{1, 3, 5}), args{2, sameParity}, false},
+		{"containsfunc#4", From([]T{[]T{1, 2}, []T{3}}), args{[]T{3}, deepEqual}, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := tt.q.Reduce(tt.args.f); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("Query.Reduce() = %v, want %v", got, tt.want)
+			if got := tt.q.ContainsFunc(tt.args.e, tt.args.eq); got != tt.want {
+				t.Errorf("Query.ContainsFunc() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
-func TestQuery_Skip(t *testing.T) {
+func TestQuery_ContainsSequence(t *testing.T) {
 	type args struct {
-		n int
+		pattern *Query
 	}
 	tests := []struct {
 		name string
 		q    *Query
 		args args
-		want *Query
+		want bool
 	}{
-		{"skip#1", From([]T{}), args{0}, From([]T{})},
-		{"skip#2", From([]T{}), args{5}, From([]T{})},
-		{"skip#3", From(span(1, 9)), args{0}, From(span(1, 9))},
-		{"skip#4", From(span(1, 9)), args{5}, From(span(6, 9))},
-		{"skip#5", From(span(1, 9)), args{8}, From([]T{9})},
-		{"skip#6", From(span(1, 9)), args{9}, From([]T{})},
-		{"skip#7", From(span(1, 9)), args{100}, From([]T{})},
-		{"skip#8", From(span(1, 9)), args{-100}, From([]T{})},
+		{"containssequence#1", From([]T{}), args{From([]T{})}, true},
+		{"containssequence#2", From(span(1, 9)), args{From([]T{})}, true},
+		{"containssequence#3", From([]T{}), args{From([]T{1})}, false},
+		{"containssequence#4", From(span(1, 9)), args{From([]T{1, 2, 3})}, true},
+		{"containssequence#5", From(span(1, 9)), args{From([]T{4, 5, 6})}, true},
+		{"containssequence#6", From(span(1, 9)), args{From([]T{7, 8, 9})}, true},
+		{"containssequence#7", From(span(1, 9)), args{From([]T{4, 6, 5})}, false},
+		{"containssequence#8", From(span(1, 9)), args{From(span(1, 10))}, false},
+		{"containssequence#9", From([]T{1, 1, 2, 1, 1, 1, 2}), args{From([]T{1, 1, 1, 2})}, true},
+		{"containssequence#10", From([]T{[]T{1}, []T{2}}), args{From([]T{[]T{2}})}, true},
+		{"containssequence#11", From([]T{[]T{1}, []T{2}}), args{From([]T{[]T{2}, []T{1}})}, false},
+		{"containssequence#12", From([]T{1, edition{"0-14-143951-3", "Emma"}}), args{From([]T{1, edition{"0-14-143951-3", "Emma (Penguin)"}})}, true},
+		{"containssequence#13", From([]T{1, edition{"0-14-143951-3", "Emma"}}), args{From([]T{edition{"0-14-143967-X", "Emma"}})}, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := tt.q.Skip(tt.args.n); !got.equal(tt.want) {
-				t.Errorf("Query.Skip() = %v, want %v", got, tt.want)
+			if got := tt.q.ContainsSequence(tt.args.pattern); got != tt.want {
+				t.Errorf("Query.ContainsSequence() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
-func TestQuery_Sort(t *testing.T) {
+// naturals returns an infinite query of the natural numbers 1, 2, 3, ...
+func naturals() *Query {
+	return &Query{Iterate: func() Iterator {
+		i := 0
+		return func() (elem T, ok bool) {
+			i++
+			return i, true
+		}
+	}}
+}
+
+func TestQuery_CountAtLeast(t *testing.T) {
+	isEven := func(e T) bool {
+		return e.(int)%2 == 0
+	}
+
 	type args struct {
-		f []func(t1, t2 T) bool
+		k int
+		f []func(T) bool
 	}
 	tests := []struct {
 		name string
 		q    *Query
 		args args
-		want *Query
+		want bool
 	}{
-		{"sortby#1", From([]T{}), args{}, From([]T{})},
-		{"sortby#2", From([]T{1}), args{[]func(t1, t2 T) bool{less, less}}, From([]T{1})},
-		{"sortby#3", From(shuffle(span(1, 9))), args{[]func(t1, t2 T) bool{less, less}}, From(span(1, 9))},
-		{"sortby#4", From(span(9, 1)), args{[]func(t1, t2 T) bool{less, less}}, From(span(1, 9))},
+		{"countatleast#1", From([]T{}), args{0, nil}, true},
+		{"countatleast#2", From([]T{}), args{1, nil}, false},
+		{"countatleast#3", From(span(1, 9)), args{9, nil}, true},
+		{"countatleast#4", From(span(1, 9)), args{10, nil}, false},
+		{"countatleast#5", From(span(1, 9)), args{4, []func(T) bool{isEven}}, true},
+		{"countatleast#6", From(span(1, 9)), args{5, []func(T) bool{isEven}}, false},
+		{"countatleast#7", From(span(1, 9)), args{1, []func(T) bool{isEven, truth(false)}}, false},
+		{"countatleast#8", naturals(), args{1000, []func(T) bool{isEven}}, true},
+		{"countatleast#9", From(span(1, 9)), args{1, []func(T) bool{nil}}, true},
+		{"countatleast#10", From(span(1, 9)), args{5, []func(T) bool{nil, isEven}}, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := tt.q.Sort(tt.args.f...); !got.equal(tt.want) {
-				t.Errorf("Query.SortBy() = %v, want %v", got, tt.want)
+			if got := tt.q.CountAtLeast(tt.args.k, tt.args.f...); got != tt.want {
+				t.Errorf("Query.CountAtLeast() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
-func TestBy_Sort(t *testing.T) {
+func TestQuery_CountAtMost(t *testing.T) {
+	isEven := func(e T) bool {
+		return e.(int)%2 == 0
+	}
+
 	type args struct {
-		t []interface{}
+		k int
+		f []func(T) bool
 	}
 	tests := []struct {
 		name string
-		by   by
+		q    *Query
 		args args
+		want bool
 	}{
-		{"sort#1", []func(t1, t2 T) bool{less, less}, args{[]interface{}{}}},
-		{"sort#2", []func(t1, t2 T) bool{less, less}, args{[]interface{}{1}}},
-		{"sort#3", []func(t1, t2 T) bool{less, less}, args{[]interface{}{1, 6, 3, 7, 5, 8, 4, 9, 2}}},
+		{"countatmost#1", From([]T{}), args{0, nil}, true},
+		{"countatmost#2", From(span(1, 9)), args{9, nil}, true},
+		{"countatmost#3", From(span(1, 9)), args{8, nil}, false},
+		{"countatmost#4", From(span(1, 9)), args{4, []func(T) bool{isEven}}, true},
+		{"countatmost#5", From(span(1, 9)), args{3, []func(T) bool{isEven}}, false},
+		{"countatmost#6", From(span(1, 9)), args{-1, nil}, false},
+		{"countatmost#7", naturals(), args{1000, []func(T) bool{isEven}}, false},
+		{"countatmost#8", From(span(1, 9)), args{8, []func(T) bool{nil}}, false},
+		{"countatmost#9", From(span(1, 9)), args{4, []func(T) bool{nil, isEven}}, true},
+		{"countatmost#10", From([]T{1, 2}), args{math.MaxInt64, nil}, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			tt.by.Sort(tt.args.t)
+			if got := tt.q.CountAtMost(tt.args.k, tt.args.f...); got != tt.want {
+				t.Errorf("Query.CountAtMost() = %v, want %v", got, tt.want)
+			}
 		})
 	}
 }
 
-func Test_sorter_Len(t *testing.T) {
+func TestQuery_CountBy(t *testing.T) {
+	parity := func(e T) interface{} {
+		if e.(int)%2 == 0 {
+			return "even"
+		}
+		return "odd"
+	}
+	type args struct {
+		keySel func(e T) interface{}
+	}
 	tests := []struct {
 		name string
-		s    sorter
-		want int
+		q    *Query
+		args args
+		want map[interface{}]int
 	}{
-		{"len#1", sorter{}, 0},
-		{"len#2", sorter{t: []interface{}{1}}, 1},
-		{"len#3", sorter{t: []interface{}{1, 6, 3, 7, 5, 8, 4, 9, 2}}, 9},
+		{"countby#1", From([]T{}), args{parity}, map[interface{}]int{}},
+		{"countby#2", From(span(1, 9)), args{parity}, map[interface{}]int{"even": 4, "odd": 5}},
+		{"countby#3", From([]T{2, 4}), args{parity}, map[interface{}]int{"even": 2}},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := tt.s.Len(); got != tt.want {
-				t.Errorf("sorter.Len() = %v, want %v", got, tt.want)
+			if got := tt.q.CountBy(tt.args.keySel); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Query.CountBy() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
-func Test_sorter_Swap(t *testing.T) {
+func TestQuery_CrossJoin(t *testing.T) {
+	pair := func(o, i T) interface{} {
+		return []T{o, i}
+	}
+
 	type args struct {
-		i int
-		j int
+		inner     *Query
+		resultSel func(o, i T) interface{}
 	}
 	tests := []struct {
 		name string
-		s    sorter
+		q    *Query
+		args args
+		want *Query
+	}{
+		{"crossjoin#1", From([]T{}), args{From([]T{}), pair}, From([]T{})},
+		{"crossjoin#2", From([]T{}), args{From(span(3, 4)), pair}, From([]T{})},
+		{"crossjoin#3", From(span(1, 2)), args{From([]T{}), pair}, From([]T{})},
+		{"crossjoin#4", From(span(1, 2)), args{From(span(3, 4)), pair}, From([]T{[]T{1, 3}, []T{1, 4}, []T{2, 3}, []T{2, 4}})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.CrossJoin(tt.args.inner, tt.args.resultSel); !got.equal(tt.want) {
+				t.Errorf("Query.CrossJoin() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_CumulativeMax(t *testing.T) {
+	tests := []struct {
+		name string
+		q    *Query
+		want *Query
+	}{
+		{"cumulativemax#1", From([]T{}), From([]T{})},
+		{"cumulativemax#2", From([]T{1, 3, 2, 5, 4}), From([]T{1, 3, 3, 5, 5})},
+		{"cumulativemax#3", From(span(9, 1)), From([]T{9, 9, 9, 9, 9, 9, 9, 9, 9})},
+		{"cumulativemax#4", From(span(1, 9)), From(span(1, 9))},
+		{"cumulativemax#5", From([]T{-1}), From([]T{-1})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.CumulativeMax(less); !got.equal(tt.want) {
+				t.Errorf("Query.CumulativeMax() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_Cycle(t *testing.T) {
+	type args struct {
+		times int
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want *Query
+	}{
+		{"cycle#1", From([]T{}), args{3}, From([]T{})},
+		{"cycle#2", From([]T{1, 2}), args{3}, From([]T{1, 2, 1, 2, 1, 2})},
+		{"cycle#3", From([]T{1, 2}), args{1}, From([]T{1, 2})},
+		{"cycle#4", From([]T{1, 2}), args{0}, From([]T{})},
+		{"cycle#5", From([]T{}), args{-1}, From([]T{})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.Cycle(tt.args.times); !got.equal(tt.want) {
+				t.Errorf("Query.Cycle() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_Cycle_infinite(t *testing.T) {
+	want := From([]T{1, 2, 1, 2, 1})
+	if got := From([]T{1, 2}).Cycle(-1).Take(5); !got.equal(want) {
+		t.Errorf("Query.Cycle() = %v, want %v", got, want)
+	}
+}
+
+func TestQuery_DefaultIfEmpty(t *testing.T) {
+	type args struct {
+		def T
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want *Query
+	}{
+		{"defaultifempty#1", From([]T{}), args{}, From([]T{nil})},
+		{"defaultifempty#2", From([]T{}), args{0}, From([]T{0})},
+		{"defaultifempty#3", From([]T{1}), args{0}, From([]T{1})},
+		{"defaultifempty#4", From(span(1, 9)), args{0}, From(span(1, 9))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.DefaultIfEmpty(tt.args.def); !got.equal(tt.want) {
+				t.Errorf("Query.DefaultIfEmpty() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_Delta(t *testing.T) {
+	tests := []struct {
+		name string
+		q    *Query
+		want *Query
+	}{
+		{"delta#1", From([]T{}), From([]T{})},
+		{"delta#2", From([]T{1}), From([]T{})},
+		{"delta#3", From([]T{1, 4, 9, 16}), From([]T{3, 5, 7})},
+		{"delta#4", From(span(9, 1)), From([]T{-1, -1, -1, -1, -1, -1, -1, -1})},
+		{"delta#5", From([]T{2, 2}), From([]T{0})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.Delta(); !got.equal(tt.want) {
+				t.Errorf("Query.Delta() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_DistinctUntilChanged(t *testing.T) {
+	tests := []struct {
+		name string
+		q    *Query
+		want *Query
+	}{
+		{"distinctuntilchanged#1", From([]T{}), From([]T{})},
+		{"distinctuntilchanged#2", From(span(1, 3)), From(span(1, 3))},
+		{"distinctuntilchanged#3", From([]T{1, 1, 2, 2, 1}), From([]T{1, 2, 1})},
+		{"distinctuntilchanged#4", From([]T{nil, nil, 1, nil}), From([]T{nil, 1, nil})},
+		{"distinctuntilchanged#5", From([]T{[]T{1}, []T{1}, []T{2}}), From([]T{[]T{1}, []T{2}})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.DistinctUntilChanged(); !got.equal(tt.want) {
+				t.Errorf("Query.DistinctUntilChanged() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_Histogram(t *testing.T) {
+	value := func(e T) float64 {
+		if f, ok := e.(float64); ok {
+			return f
+		}
+		return float64(e.(int))
+	}
+	type args struct {
+		min     float64
+		max     float64
+		buckets int
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want []int
+	}{
+		{"histogram#1", From([]T{}), args{1, 10, 5}, []int{0, 0, 0, 0, 0}},
+		{"histogram#2", From(span(1, 10)), args{1, 10, 5}, []int{2, 2, 2, 2, 2}},
+		{"histogram#3", From(span(1, 10)), args{0, 10, 2}, []int{4, 6}},
+		{"histogram#4", From([]T{-5, 0, 10, 50}), args{0, 10, 2}, []int{2, 2}},
+		{"histogram#5", From(span(1, 10)), args{1, 10, 1}, []int{10}},
+		{"histogram#6", From(span(1, 10)), args{5, 5, 3}, []int{10, 0, 0}},
+		{"histogram#7", From(span(1, 10)), args{1, 10, 0}, []int{}},
+		{"histogram#8", From([]T{math.Inf(1), 1e300}), args{0, 10, 5}, []int{0, 0, 0, 0, 2}},
+		{"histogram#9", From([]T{math.Inf(-1), -1e300, math.NaN()}), args{0, 10, 5}, []int{3, 0, 0, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.Histogram(value, tt.args.min, tt.args.max, tt.args.buckets); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Query.Histogram() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_Indexed(t *testing.T) {
+	type args struct {
+		i int
+	}
+	tests := []struct {
+		name    string
+		q       *Query
+		args    args
+		want    T
+		wantLen int
+	}{
+		{"indexed#1", From([]T{}), args{0}, nil, 0},
+		{"indexed#2", From(span(1, 9)), args{0}, 1, 9},
+		{"indexed#3", From(span(1, 9)), args{5}, 6, 9},
+		{"indexed#4", From(span(1, 9)), args{8}, 9, 9},
+		{"indexed#5", From(span(1, 9)), args{9}, nil, 9},
+		{"indexed#6", From(span(1, 9)), args{-1}, nil, 9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			x := tt.q.Indexed()
+			if got := x.At(tt.args.i); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("IndexedQuery.At() = %v, want %v", got, tt.want)
+			}
+			if got := x.Len(); got != tt.wantLen {
+				t.Errorf("IndexedQuery.Len() = %v, want %v", got, tt.wantLen)
+			}
+		})
+	}
+}
+
+func TestQuery_Indexed_iteratesOnce(t *testing.T) {
+	n := 0
+	x := From(span(1, 9)).
+		MapTo(func(e T) T {
+			n++
+			return e
+		}).
+		Indexed()
+	for i := 0; i < 100; i++ {
+		x.At(i % x.Len())
+	}
+	if n != 9 {
+		t.Errorf("Query.Indexed() iterated %v elements, want 9", n)
+	}
+}
+
+func TestQuery_IndexOf(t *testing.T) {
+	type args struct {
+		e T
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want int
+	}{
+		{"indexof#1", From([]T{}), args{}, -1},
+		{"indexof#2", From([]T{}), args{42}, -1},
+		{"indexof#3", From(span(1, 9)), args{1}, 0},
+		{"indexof#4", From(span(1, 9)), args{5}, 4},
+		{"indexof#5", From(span(1, 9)), args{9}, 8},
+		{"indexof#6", From(span(1, 9)), args{42}, -1},
+		{"indexof#7", From([]T{1, 2, 1}), args{1}, 0},
+		{"indexof#8", From([]T{[]T{1}, []T{2}}), args{[]T{2}}, 1},
+		{"indexof#9", From([]T{edition{"1", "Emma"}, edition{"2", "Persuasion"}}), args{edition{"2", ""}}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.IndexOf(tt.args.e); got != tt.want {
+				t.Errorf("Query.IndexOf() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_Insert(t *testing.T) {
+	type args struct {
+		i int
+		e T
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want *Query
+	}{
+		{"insert#1", From([]T{}), args{0, 99}, From([]T{99})},
+		{"insert#2", From([]T{}), args{5, 99}, From([]T{99})},
+		{"insert#3", From(span(1, 4)), args{2, 99}, From([]T{1, 2, 99, 3, 4})},
+		{"insert#4", From(span(1, 4)), args{0, 99}, From([]T{99, 1, 2, 3, 4})},
+		{"insert#5", From(span(1, 4)), args{-1, 99}, From([]T{99, 1, 2, 3, 4})},
+		{"insert#6", From(span(1, 4)), args{4, 99}, From([]T{1, 2, 3, 4, 99})},
+		{"insert#7", From(span(1, 4)), args{100, 99}, From([]T{1, 2, 3, 4, 99})},
+		{"insert#8", From([]T{nil}), args{1, nil}, From([]T{nil, nil})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.Insert(tt.args.i, tt.args.e); !got.equal(tt.want) {
+				t.Errorf("Query.Insert() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_Intersperse(t *testing.T) {
+	type args struct {
+		sep T
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want *Query
+	}{
+		{"intersperse#1", From([]T{}), args{0}, From([]T{})},
+		{"intersperse#2", From([]T{1}), args{0}, From([]T{1})},
+		{"intersperse#3", From(span(1, 2)), args{0}, From([]T{1, 0, 2})},
+		{"intersperse#4", From(span(1, 3)), args{0}, From([]T{1, 0, 2, 0, 3})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.Intersperse(tt.args.sep); !got.equal(tt.want) {
+				t.Errorf("Query.Intersperse() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_Empty(t *testing.T) {
+	tests := []struct {
+		name string
+		q    *Query
+		want bool
+	}{
+		{"empty#1", From([]T{}), true},
+		{"empty#2", From([]T{1}), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.IsEmpty(); got != tt.want {
+				t.Errorf("Query.Empty() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_Err(t *testing.T) {
+	tests := []struct {
+		name  string
+		q     *Query
+		drain func(q *Query)
+	}{
+		{"err#1", From([]T{}), func(q *Query) {}},
+		{"err#2", From(span(1, 9)), func(q *Query) { ToSlice(q) }},
+		{"err#3", From(span(1, 9)).Where(truth(true)), func(q *Query) { q.ForEach(func(T) {}) }},
+		{"err#4", FromLines(strings.NewReader("a\nb\n")), func(q *Query) { ToSlice(q) }},
+		{"err#5", From(span(1, 9)).TryMapTo(func(e T) (T, error) { return e, nil }), func(q *Query) { q.ForEach(func(T) {}) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.drain(tt.q)
+			if err := tt.q.Err(); err != nil {
+				t.Errorf("Query.Err() = %v, want nil", err)
+			}
+		})
+	}
+}
+
+func TestQuery_Err_chained(t *testing.T) {
+	errFailed := errors.New("failed")
+	failOn2 := func(e T) (T, error) {
+		if e == 2 {
+			return nil, errFailed
+		}
+		return e, nil
+	}
+	odd := func(e T) bool {
+		return e.(int)%2 == 1
+	}
+	longLine := strings.Repeat("x", 100*1024) + "\n"
+	tests := []struct {
+		name string
+		q    *Query
+		want *Query
+		err  error
+	}{
+		{"errchained#1", From(span(1, 3)).TryMapTo(failOn2).Where(odd), From([]T{1}), errFailed},
+		{"errchained#2", From(span(1, 3)).TryMapTo(failOn2).MapTo(nil).Skip(0), From([]T{1}), errFailed},
+		{"errchained#3", From(span(1, 3)).TryMapTo(failOn2).Materialize(), From([]T{1}), errFailed},
+		{"errchained#4", From(span(4, 5)).Join(From(span(1, 3)).TryMapTo(failOn2),
+			func(e T) interface{} { return e },
+			func(e T) interface{} { return e },
+			func(o, i interface{}) interface{} { return o }), From([]T{}), errFailed},
+		{"errchained#5", Merge(From([]T{0}), From(span(1, 3)).TryMapTo(failOn2)), From([]T{0, 1}), errFailed},
+		{"errchained#6", FromLines(strings.NewReader(longLine)).Where(truth(true)), From([]T{}), bufio.ErrTooLong},
+		{"errchained#7", From(span(1, 3)).Where(odd), From([]T{1, 3}), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q; !got.equal(tt.want) {
+				t.Errorf("Query = %v, want %v", got, tt.want)
+			}
+			if err := tt.q.Err(); err != tt.err {
+				t.Errorf("Query.Err() = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestQuery_Err_concurrent(t *testing.T) {
+	errFailed := errors.New("failed")
+	q := From(span(1, 100)).TryMapTo(func(e T) (T, error) {
+		if e == 100 {
+			return nil, errFailed
+		}
+		return e, nil
+	})
+	r := MergeConcurrent(q, q)
+	if got := len(ToSlice(r)); got != 198 {
+		t.Errorf("MergeConcurrent() yielded %v elements, want 198", got)
+	}
+	if err := r.Err(); err != errFailed {
+		t.Errorf("Query.Err() = %v, want %v", err, errFailed)
+	}
+}
+
+func TestQuery_setErr(t *testing.T) {
+	errFailed := errors.New("failed")
+	q := From(span(1, 9))
+	q.setErr(errFailed)
+	if err := q.Err(); err != errFailed {
+		t.Errorf("Query.Err() = %v, want %v", err, errFailed)
+	}
+	q.setErr(nil)
+	if err := q.Err(); err != nil {
+		t.Errorf("Query.Err() = %v, want nil", err)
+	}
+}
+
+func TestQuery_Every(t *testing.T) {
+	type args struct {
+		f []func(T) bool
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want bool
+	}{
+		{"every#1", From([]T{}), args{}, true},
+		{"every#2", From([]T{}), args{[]func(T) bool{truth(true), truth(true)}}, true},
+		{"every#3", From([]T{}), args{[]func(T) bool{truth(false), truth(false)}}, true},
+		{"every#4", From(span(1, 9)), args{[]func(T) bool{truth(false), truth(false)}}, false},
+		{"every#5", From(span(1, 9)), args{[]func(T) bool{truth(true), truth(true)}}, true},
+		{"every#6", From(span(1, 9)), args{[]func(T) bool{nil, truth(true)}}, true},
+		{"every#7", From(span(1, 9)), args{[]func(T) bool{nil, truth(false)}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.Every(tt.args.f...); got != tt.want {
+				t.Errorf("Query.Every() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_First(t *testing.T) {
+	tests := []struct {
+		name string
+		q    *Query
+		want T
+	}{
+		{"first#1", From([]T{}), nil},
+		{"first#2", From([]T{1}), 1},
+		{"first#3", From(span(1, 9)), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.First(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Query.First() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_FirstWhere(t *testing.T) {
+	type args struct {
+		f []func(T) bool
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want T
+	}{
+		{"firstwhere#1", From([]T{}), args{}, nil},
+		{"firstwhere#2", From([]T{}), args{[]func(T) bool{truth(true)}}, nil},
+		{"firstwhere#3", From(span(1, 9)), args{}, 1},
+		{"firstwhere#4", From(span(1, 9)), args{[]func(T) bool{truth(true), truth(false)}}, nil},
+		{"firstwhere#5", From(span(1, 9)),
+			args{[]func(T) bool{func(e T) bool {
+				return e.(int) > 3
+			}}}, 4},
+		{"firstwhere#6", From(span(1, 9)),
+			args{[]func(T) bool{func(e T) bool {
+				return e.(int) > 100
+			}}}, nil},
+		{"firstwhere#7", From(span(1, 9)), args{[]func(T) bool{nil}}, 1},
+		{"firstwhere#8", From(span(1, 9)), args{[]func(T) bool{nil, truth(false)}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.FirstWhere(tt.args.f...); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Query.FirstWhere() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_Flatten(t *testing.T) {
+	tests := []struct {
+		name string
+		q    *Query
+		want *Query
+	}{
+		{"flatten#1", From([]T{}), From([]T{})},
+		{"flatten#2", From(span(1, 3)), From(span(1, 3))},
+		{"flatten#3", From([]T{[]T{1, 2}, []T{3}}), From(span(1, 3))},
+		{"flatten#4", From([]T{[]T{}, 1, []T{2, 3}, []T{}}), From(span(1, 3))},
+		{"flatten#5", From([]T{[]interface{}{1, 2}, 3}), From(span(1, 3))},
+		{"flatten#6", From([]T{[]T{1, []T{2, 3}}}), From([]T{1, []T{2, 3}})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.Flatten(); !got.equal(tt.want) {
+				t.Errorf("Query.Flatten() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_Fold(t *testing.T) {
+	type args struct {
+		v T
+		f func(t1, t2 T) interface{}
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want interface{}
+	}{
+		{"fold#1", From([]T{}), args{}, nil},
+		{"fold#2", From([]T{}), args{0, sum}, 0},
+		{"fold#3", From([]T{}), args{10, sum}, 10},
+		{"fold#4", From([]T{}), args{-10, sum}, -10},
+		{"fold#5", From(span(1, 9)), args{0, sum}, 45},
+		{"fold#6", From(span(1, 9)), args{10, sum}, 55},
+		{"fold#7", From(span(1, 9)), args{-10, sum}, 35},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.Fold(tt.args.v, tt.args.f); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Query.Fold() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_FoldIndexed(t *testing.T) {
+	weighted := func(i int, acc, e T) interface{} {
+		return acc.(int) + (i+1)*e.(int)
+	}
+	type args struct {
+		v T
+		f func(i int, acc, e T) interface{}
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want interface{}
+	}{
+		{"foldindexed#1", From([]T{}), args{0, weighted}, 0},
+		{"foldindexed#2", From(span(1, 4)), args{0, weighted}, 1*1 + 2*2 + 3*3 + 4*4},
+		{"foldindexed#3", From(span(1, 4)), args{10, weighted}, 10 + 30},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.FoldIndexed(tt.args.v, tt.args.f); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Query.FoldIndexed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_FoldTrace(t *testing.T) {
+	type args struct {
+		v T
+		f func(t1, t2 T) interface{}
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want *Query
+	}{
+		{"foldtrace#1", From([]T{}), args{}, From([]T{})},
+		{"foldtrace#2", From([]T{}), args{0, sum}, From([]T{})},
+		{"foldtrace#3", From(span(1, 3)), args{0, sum}, From([]T{FoldStep{1, 1}, FoldStep{2, 3}, FoldStep{3, 6}})},
+		{"foldtrace#4", From(span(1, 3)), args{10, sum}, From([]T{FoldStep{1, 11}, FoldStep{2, 13}, FoldStep{3, 16}})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.FoldTrace(tt.args.v, tt.args.f); !got.equal(tt.want) {
+				t.Errorf("Query.FoldTrace() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_ForEach(t *testing.T) {
+	type args struct {
+		f func(T)
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+	}{
+		{"foreach#1", From([]T{}), args{}},
+		{"foreach#2", From(span(1, 9)), args{print}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.q.ForEach(tt.args.f)
+		})
+	}
+}
+
+func TestQuery_ForEachContext(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name    string
+		q       *Query
+		ctx     context.Context
+		want    int
+		wantErr error
+	}{
+		{"foreachcontext#1", From([]T{}), context.Background(), 0, nil},
+		{"foreachcontext#2", From(span(1, 9)), context.Background(), 9, nil},
+		{"foreachcontext#3", From(span(1, 9)), canceled, 0, context.Canceled},
+		{"foreachcontext#4", From([]T{}), canceled, 0, context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := 0
+			err := tt.q.ForEachContext(tt.ctx, func(e T) {
+				got++
+			})
+			if err != tt.wantErr || got != tt.want {
+				t.Errorf("Query.ForEachContext() = %v, %v, want %v, %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestQuery_ForEachContext_cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	got := 0
+	err := From(span(1, 1000000)).ForEachContext(ctx, func(e T) {
+		got++
+		if e.(int) == 5 {
+			cancel()
+		}
+	})
+	if err != context.Canceled || got != 5 {
+		t.Errorf("Query.ForEachContext() = %v, %v, want 5, %v", got, err, context.Canceled)
+	}
+}
+
+func TestQuery_ForEachContext_pull(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	iterated, pulled := 0, 0
+	q := &Query{Iterate: func() Iterator {
+		iterated++
+		return From(span(1, 9)).Tap(func(e T) {
+			pulled++
+		}).Iterate()
+	}}
+	err := q.ForEachContext(ctx, func(e T) {})
+	if err != context.Canceled || iterated != 0 || pulled != 0 {
+		t.Errorf("Query.ForEachContext() = %v, iterated %v, pulled %v, want %v, 0, 0", err, iterated, pulled, context.Canceled)
+	}
+}
+
+func TestQuery_ForEachIndexed(t *testing.T) {
+	tests := []struct {
+		name        string
+		q           *Query
+		wantIndices []int
+		wantElems   []T
+	}{
+		{"foreachindexed#1", From([]T{}), nil, nil},
+		{"foreachindexed#2", From(span(1, 5)), []int{0, 1, 2, 3, 4}, span(1, 5)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var indices []int
+			var elems []T
+			tt.q.ForEachIndexed(func(i int, e T) {
+				indices = append(indices, i)
+				elems = append(elems, e)
+			})
+			if !reflect.DeepEqual(indices, tt.wantIndices) || !reflect.DeepEqual(elems, tt.wantElems) {
+				t.Errorf("Query.ForEachIndexed() = %v, %v, want %v, %v", indices, elems, tt.wantIndices, tt.wantElems)
+			}
+		})
+	}
+}
+
+func TestQuery_ForEachParallel(t *testing.T) {
+	type args struct {
+		workers int
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want int
+	}{
+		{"foreachparallel#1", From([]T{}), args{4}, 0},
+		{"foreachparallel#2", From(span(1, 1000)), args{4}, 1000},
+		{"foreachparallel#3", From(span(1, 1000)), args{1}, 1000},
+		{"foreachparallel#4", From(span(1, 1000)), args{0}, 1000},
+		{"foreachparallel#5", From(span(1, 10)), args{100}, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := make([]int32, tt.want+1)
+			var total int32
+			tt.q.ForEachParallel(tt.args.workers, func(e T) {
+				atomic.AddInt32(&calls[e.(int)], 1)
+				atomic.AddInt32(&total, 1)
+			})
+			if int(total) != tt.want {
+				t.Errorf("Query.ForEachParallel() calls = %v, want %v", total, tt.want)
+			}
+			for i := 1; i <= tt.want; i++ {
+				if calls[i] != 1 {
+					t.Errorf("Query.ForEachParallel() calls for %v = %v, want 1", i, calls[i])
+				}
+			}
+		})
+	}
+}
+
+func TestQuery_ForEachUntil(t *testing.T) {
+	type args struct {
+		stop int
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want []T
+	}{
+		{"foreachuntil#1", From([]T{}), args{5}, nil},
+		{"foreachuntil#2", From(span(1, 9)), args{5}, span(1, 5)},
+		{"foreachuntil#3", From(span(1, 3)), args{5}, span(1, 3)},
+		{"foreachuntil#4", From(span(1, 3)), args{1}, []T{1}},
+		{"foreachuntil#5", naturals(), args{5}, span(1, 5)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []T
+			tt.q.ForEachUntil(func(e T) bool {
+				got = append(got, e)
+				return e.(int) < tt.args.stop
+			})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Query.ForEachUntil() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_ForEachWithLookahead(t *testing.T) {
+	type call struct {
+		cur   T
+		ahead []T
+	}
+	type args struct {
+		k int
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want []call
+	}{
+		{"foreachwithlookahead#1", From([]T{}), args{2}, nil},
+		{"foreachwithlookahead#2", From([]T{1}), args{2}, []call{{1, []T{}}}},
+		{"foreachwithlookahead#3", From(span(1, 4)), args{0}, []call{{1, []T{}}, {2, []T{}}, {3, []T{}}, {4, []T{}}}},
+		{"foreachwithlookahead#4", From(span(1, 4)), args{-1}, []call{{1, []T{}}, {2, []T{}}, {3, []T{}}, {4, []T{}}}},
+		{"foreachwithlookahead#5", From(span(1, 4)), args{2}, []call{{1, []T{2, 3}}, {2, []T{3, 4}}, {3, []T{4}}, {4, []T{}}}},
+		{"foreachwithlookahead#6", From(span(1, 4)), args{10}, []call{{1, []T{2, 3, 4}}, {2, []T{3, 4}}, {3, []T{4}}, {4, []T{}}}},
+		{"foreachwithlookahead#7", From(span(1, 3)), args{math.MaxInt64}, []call{{1, []T{2, 3}}, {2, []T{3}}, {3, []T{}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []call
+			tt.q.ForEachWithLookahead(tt.args.k, func(cur T, ahead []T) {
+				got = append(got, call{cur, append([]T{}, ahead...)})
+			})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Query.ForEachWithLookahead() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_ExactlyN(t *testing.T) {
+	isEven := func(e T) bool {
+		return e.(int)%2 == 0
+	}
+
+	type args struct {
+		n int
+		f []func(T) bool
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want bool
+	}{
+		{"exactlyn#1", From([]T{}), args{0, nil}, true},
+		{"exactlyn#2", From([]T{}), args{1, nil}, false},
+		{"exactlyn#3", From(span(1, 9)), args{3, []func(T) bool{isEven}}, false},
+		{"exactlyn#4", From(span(1, 9)), args{4, []func(T) bool{isEven}}, true},
+		{"exactlyn#5", From(span(1, 9)), args{5, []func(T) bool{isEven}}, false},
+		{"exactlyn#6", From(span(1, 9)), args{0, []func(T) bool{isEven, truth(false)}}, true},
+		{"exactlyn#7", From(span(1, 9)), args{-1, nil}, false},
+		{"exactlyn#8", naturals(), args{1000, []func(T) bool{isEven}}, false},
+		{"exactlyn#9", From(span(1, 9)), args{9, []func(T) bool{nil}}, true},
+		{"exactlyn#10", From(span(1, 9)), args{4, []func(T) bool{nil, isEven}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.ExactlyN(tt.args.n, tt.args.f...); got != tt.want {
+				t.Errorf("Query.ExactlyN() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_Expand(t *testing.T) {
+	type args struct {
+		f func(e T) []T
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want *Query
+	}{
+		{"expand#1", From([]T{}), args{null}, From([]T{})},
+		{"expand#2", From([]T{1, 2, 3}), args{null}, From([]T{})},
+		{"expand#3", From([]T{}), args{copy}, From([]T{})},
+		{"expand#4", From([]T{1, 2, 3}), args{copy}, From([]T{1, 2, 3})},
+		{"expand#5", From([]T{}), args{duplicate}, From([]T{})},
+		{"expand#6", From([]T{1, 2, 3}), args{duplicate}, From([]T{1, 1, 2, 2, 3, 3})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.Expand(tt.args.f); !got.equal(tt.want) {
+				t.Errorf("Query.Expand() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_Format(t *testing.T) {
+	type args struct {
+		prefix string
+		sep    string
+		suffix string
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want string
+	}{
+		{"format#1", From([]T{}), args{"(", ",", ")"}, "()"},
+		{"format#2", From(span(1, 3)), args{"(", ",", ")"}, "(1,2,3)"},
+		{"format#3", From(span(1, 3)), args{"", ";", ""}, "1;2;3"},
+		{"format#4", From([]T{1}), args{"[", ", ", "]"}, "[1]"},
+		{"format#5", From([]T{"", "", nil}), args{"", ",", ""}, ",,<nil>"},
+		{"format#6", From(span(1, 9)), args{"[", " ", "]"}, From(span(1, 9)).String()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.Format(tt.args.prefix, tt.args.sep, tt.args.suffix); got != tt.want {
+				t.Errorf("Query.Format() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFrom(t *testing.T) {
+	type args struct {
+		t []T
+	}
+	tests := []struct {
+		name string
+		args args
+		want *Query
+	}{
+		{"from#1", args{[]T{}}, From([]T{})},
+		{"from#2", args{span(1, 9)}, From(span(1, 9))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := From(tt.args.t); !got.equal(tt.want) {
+				t.Errorf("From() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromIterator(t *testing.T) {
+	counter := func(n int) func() Iterator {
+		return func() Iterator {
+			i := 0
+			return func() (elem T, ok bool) {
+				if i < n {
+					i++
+					return i, true
+				}
+				return
+			}
+		}
+	}
+	even := func(e T) bool {
+		return e.(int)%2 == 0
+	}
+	type args struct {
+		make func() Iterator
+	}
+	tests := []struct {
+		name string
+		args args
+		want *Query
+	}{
+		{"fromiterator#1", args{counter(0)}, From([]T{})},
+		{"fromiterator#2", args{counter(9)}, From(span(1, 9))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := FromIterator(tt.args.make)
+			if !got.equal(tt.want) {
+				t.Errorf("FromIterator() = %v, want %v", got, tt.want)
+			}
+			if !got.equal(tt.want) {
+				t.Errorf("FromIterator() second iteration = %v, want %v", got, tt.want)
+			}
+			if w, v := tt.want.Where(even), got.Where(even); !v.equal(w) {
+				t.Errorf("FromIterator().Where() = %v, want %v", v, w)
+			}
+		})
+	}
+}
+
+func TestFromLines(t *testing.T) {
+	hasError := func(e T) bool {
+		return strings.Contains(e.(string), "ERROR")
+	}
+
+	type args struct {
+		r io.Reader
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    *Query
+		wantErr error
+	}{
+		{"fromlines#1", args{strings.NewReader("")}, From([]T{}), nil},
+		{"fromlines#2", args{strings.NewReader("INFO start\nERROR disk\nINFO stop\nERROR net\n")}, From([]T{"ERROR disk", "ERROR net"}), nil},
+		{"fromlines#3", args{strings.NewReader("ERROR last")}, From([]T{"ERROR last"}), nil},
+		{"fromlines#4", args{iotest.ErrReader(io.ErrUnexpectedEOF)}, From([]T{}), io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := FromLines(tt.args.r)
+			if got := q.Where(hasError); !got.equal(tt.want) {
+				t.Errorf("FromLines() = %v, want %v", got, tt.want)
+			}
+			if err := q.Err(); err != tt.wantErr {
+				t.Errorf("FromLines() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// fakeDriver is a database/sql driver whose queries return the rows of a fakeTable.
+type fakeDriver struct{}
+
+// fakeTable is the result set returned by every query of fakeDriver.
+var fakeTable = struct {
+	cols []string
+	rows [][]driver.Value
+}{
+	[]string{"id", "title", "year"},
+	[][]driver.Value{
+		{int64(1), "Sense & Sensibility", int64(1811)},
+		{int64(2), "Pride & Prejudice", int64(1813)},
+		{int64(4), "Emma", int64(1815)},
+	},
+}
+
+// errBrokenRows is returned by the rows of a fakeDriver query containing "broken"
+// after the second row.
+var errBrokenRows = errors.New("broken rows")
+
+type fakeConn struct{}
+
+type fakeStmt struct {
+	query string
+}
+
+type fakeRows struct {
+	i      int
+	broken bool
+}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	return fakeConn{}, nil
+}
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeStmt{query}, nil
+}
+
+func (fakeConn) Close() error {
+	return nil
+}
+
+func (fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("not supported")
+}
+
+func (fakeStmt) Close() error {
+	return nil
+}
+
+func (fakeStmt) NumInput() int {
+	return -1
+}
+
+func (fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("not supported")
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return &fakeRows{broken: strings.Contains(s.query, "broken")}, nil
+}
+
+func (r *fakeRows) Columns() []string {
+	return fakeTable.cols
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.broken && r.i == 2 {
+		return errBrokenRows
+	}
+	if r.i >= len(fakeTable.rows) {
+		return io.EOF
+	}
+	for i, v := range fakeTable.rows[r.i] {
+		dest[i] = v
+	}
+	r.i++
+	return nil
+}
+
+func init() {
+	sql.Register("query-fake", fakeDriver{})
+}
+
+func TestFromRows(t *testing.T) {
+	db, err := sql.Open("query-fake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query("SELECT id, title, year FROM books")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := FromRows(rows)
+	if err != nil {
+		t.Fatalf("FromRows() error = %v", err)
+	}
+	got := q.
+		Where(func(e T) bool {
+			return e.(map[string]interface{})["year"].(int64) > 1811
+		}).
+		MapTo(func(e T) T {
+			return e.(map[string]interface{})["title"]
+		})
+	want := From([]T{"Pride & Prejudice", "Emma"})
+	if !got.equal(want) {
+		t.Errorf("FromRows() = %v, want %v", got, want)
+	}
+	if err := rows.Err(); err != nil {
+		t.Errorf("FromRows() rows error = %v", err)
+	}
+	if err := rows.Scan(); err == nil {
+		t.Errorf("FromRows() rows not closed after exhaustion")
+	}
+}
+
+func TestFromRows_err(t *testing.T) {
+	db, err := sql.Open("query-fake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query("SELECT id, title, year FROM broken")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := FromRows(rows)
+	if err != nil {
+		t.Fatalf("FromRows() error = %v", err)
+	}
+	got := q.MapTo(func(e T) T {
+		return e.(map[string]interface{})["id"]
+	})
+	want := From([]T{int64(1), int64(2)})
+	if !got.equal(want) {
+		t.Errorf("FromRows() = %v, want %v", got, want)
+	}
+	if err := got.Err(); err != errBrokenRows {
+		t.Errorf("FromRows() error = %v, want %v", err, errBrokenRows)
+	}
+}
+
+func TestQuery_FullOuterJoin(t *testing.T) {
+	keySel := func(e T) interface{} {
+		return e
+	}
+	resultSel := func(o, i interface{}) interface{} {
+		return []T{o, i}
+	}
+
+	type args struct {
+		inner     *Query
+		outKeySel func(T) interface{}
+		innKeySel func(T) interface{}
+		resultSel func(o, i interface{}) interface{}
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want *Query
+	}{
+		{"fullouterjoin#1", From([]T{}), args{From([]T{}), nil, nil, nil}, From([]T{})},
+		{"fullouterjoin#2", From([]T{}), args{From(span(1, 2)), keySel, keySel, resultSel}, From([]T{[]T{nil, 1}, []T{nil, 2}})},
+		{"fullouterjoin#3", From(span(1, 2)), args{From([]T{}), keySel, keySel, resultSel}, From([]T{[]T{1, nil}, []T{2, nil}})},
+		{"fullouterjoin#4", From(span(1, 4)), args{From(span(3, 6)), keySel, keySel, resultSel},
+			From([]T{[]T{1, nil}, []T{2, nil}, []T{3, 3}, []T{4, 4}, []T{nil, 5}, []T{nil, 6}})},
+		{"fullouterjoin#5", From([]T{1, 2, 2}), args{From([]T{3, 2, 2}), keySel, keySel, resultSel},
+			From([]T{[]T{1, nil}, []T{2, 2}, []T{2, 2}, []T{2, 2}, []T{2, 2}, []T{nil, 3}})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.FullOuterJoin(tt.args.inner, tt.args.outKeySel, tt.args.innKeySel, tt.args.resultSel); !got.equal(tt.want) {
+				t.Errorf("Query.FullOuterJoin() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_GroupAdjacentBy(t *testing.T) {
+	books := []T{
+		AuthorTitleYear{"Austen, Jane", "Emma", 1815},
+		AuthorTitleYear{"Austen, Jane", "Persuasion", 1817},
+		AuthorTitleYear{"Brontë, Emily", "Wuthering Heights", 1847},
+		AuthorTitleYear{"Hunter, Rachel", "Family Annals", 1807},
+		AuthorTitleYear{"Hunter, Rachel", "The Schoolmistress", 1811},
+	}
+	author := func(e T) interface{} {
+		return e.(AuthorTitleYear).Author
+	}
+	parity := func(e T) interface{} {
+		return e.(int) % 2
+	}
+	slice := func(e T) interface{} {
+		return []int{e.(int) / 10}
+	}
+	type args struct {
+		keySel func(e T) interface{}
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want []interface{}
+	}{
+		{"groupadjacentby#1", From([]T{}), args{parity}, []interface{}{}},
+		{"groupadjacentby#2", From(books), args{author}, []interface{}{
+			Group{"Austen, Jane", books[0:2]},
+			Group{"Brontë, Emily", books[2:3]},
+			Group{"Hunter, Rachel", books[3:5]},
+		}},
+		{"groupadjacentby#3", From([]T{1, 3, 2, 4, 6, 5}), args{parity}, []interface{}{
+			Group{1, []T{1, 3}},
+			Group{0, []T{2, 4, 6}},
+			Group{1, []T{5}},
+		}},
+		{"groupadjacentby#4", From([]T{1}), args{parity}, []interface{}{
+			Group{1, []T{1}},
+		}},
+		{"groupadjacentby#5", From([]T{1, 2, 11}), args{slice}, []interface{}{
+			Group{[]int{0}, []T{1, 2}},
+			Group{[]int{1}, []T{11}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ToSlice(tt.q.GroupAdjacentBy(tt.args.keySel)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Query.GroupAdjacentBy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_Join(t *testing.T) {
+	keySel := func(e T) interface{} {
+		return e
+	}
+	resultSel := func(o, i interface{}) interface{} {
+		return o
+	}
+
+	type args struct {
+		inner     *Query
+		outKeySel func(T) interface{}
+		innKeySel func(T) interface{}
+		resultSel func(o, i interface{}) interface{}
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want *Query
+	}{
+		{"join#1", From([]T{}), args{From([]T{}), nil, nil, nil}, From([]T{})},
+		{"join#2", From([]T{}), args{From([]T{}), keySel, keySel, resultSel}, From([]T{})},
+		{"join#3", From(span(1, 9)), args{From([]T{}), keySel, keySel, resultSel}, From([]T{})},
+		{"join#4", From(span(4, 9)), args{From(span(1, 6)), keySel, keySel, resultSel}, From(span(4, 6))},
+		{"join#5", From(span(1, 6)), args{From(span(6, 9)), keySel, keySel, resultSel}, From([]T{6})},
+		{"join#6", From(span(1, 5)), args{From(span(6, 9)), keySel, keySel, resultSel}, From([]T{})},
+		{"join#7", From([]T{}), args{From(span(6, 9)), keySel, keySel, resultSel}, From([]T{})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.Join(tt.args.inner, tt.args.outKeySel, tt.args.innKeySel, tt.args.resultSel); !got.equal(tt.want) {
+				t.Errorf("Query.Join() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_KthLargest(t *testing.T) {
+	type args struct {
+		k int
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want T
+	}{
+		{"kthlargest#1", From([]T{}), args{1}, nil},
+		{"kthlargest#2", From(shuffle(span(1, 9))), args{1}, 9},
+		{"kthlargest#3", From(shuffle(span(1, 9))), args{9}, 1},
+		{"kthlargest#4", From(shuffle(span(1, 9))), args{3}, 7},
+		{"kthlargest#5", From(shuffle(span(1, 9))), args{10}, nil},
+		{"kthlargest#6", From(shuffle(span(1, 9))), args{0}, nil},
+		{"kthlargest#7", From(shuffle(span(1, 9))), args{-1}, nil},
+		{"kthlargest#8", From([]T{3, 1, 3, 2}), args{2}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.KthLargest(tt.args.k, less); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Query.KthLargest() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_Lag(t *testing.T) {
+	type args struct {
+		offset int
+		fill   T
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want *Query
+	}{
+		{"lag#1", From([]T{}), args{1, 0}, From([]T{})},
+		{"lag#2", From(span(1, 5)), args{1, 0}, From([]T{0, 1, 2, 3, 4})},
+		{"lag#3", From(span(1, 5)), args{2, nil}, From([]T{nil, nil, 1, 2, 3})},
+		{"lag#4", From(span(1, 5)), args{5, 0}, From([]T{0, 0, 0, 0, 0})},
+		{"lag#5", From(span(1, 3)), args{10, 0}, From([]T{0, 0, 0})},
+		{"lag#6", From(span(1, 5)), args{0, 0}, From(span(1, 5))},
+		{"lag#7", From(span(1, 5)), args{-1, 0}, From(span(1, 5))},
+		{"lag#8", From(span(1, 3)), args{math.MaxInt64, 0}, From([]T{0, 0, 0})},
+		{"lag#9", From(span(1, 7)), args{3, 0}, From([]T{0, 0, 0, 1, 2, 3, 4})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.Lag(tt.args.offset, tt.args.fill); !got.equal(tt.want) {
+				t.Errorf("Query.Lag() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_LagPairs(t *testing.T) {
+	type args struct {
+		lag int
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want *Query
+	}{
+		{"lagpairs#1", From([]T{}), args{2}, From([]T{})},
+		{"lagpairs#2", From(span(1, 5)), args{2}, From([]T{[]T{1, 3}, []T{2, 4}, []T{3, 5}})},
+		{"lagpairs#3", From(span(1, 3)), args{1}, From([]T{[]T{1, 2}, []T{2, 3}})},
+		{"lagpairs#4", From(span(1, 3)), args{0}, From([]T{[]T{1, 1}, []T{2, 2}, []T{3, 3}})},
+		{"lagpairs#5", From(span(1, 3)), args{-1}, From([]T{[]T{1, 1}, []T{2, 2}, []T{3, 3}})},
+		{"lagpairs#6", From(span(1, 3)), args{3}, From([]T{})},
+		{"lagpairs#7", From(span(1, 3)), args{10}, From([]T{})},
+		{"lagpairs#8", From(span(1, 3)), args{math.MaxInt64}, From([]T{})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.LagPairs(tt.args.lag); !got.equal(tt.want) {
+				t.Errorf("Query.LagPairs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_Last(t *testing.T) {
+	tests := []struct {
+		name string
+		q    *Query
+		want T
+	}{
+		{"last#1", From([]T{}), nil},
+		{"last#2", From([]T{1}), 1},
+		{"last#3", From(span(1, 9)), 9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.Last(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Query.Last() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_LastWhere(t *testing.T) {
+	type args struct {
+		f []func(T) bool
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want T
+	}{
+		{"lastwhere#1", From([]T{}), args{}, nil},
+		{"lastwhere#2", From([]T{}), args{[]func(T) bool{truth(true)}}, nil},
+		{"lastwhere#3", From(span(1, 9)), args{}, 9},
+		{"lastwhere#4", From(span(1, 9)), args{[]func(T) bool{truth(true), truth(false)}}, nil},
+		{"lastwhere#5", From(span(1, 9)),
+			args{[]func(T) bool{func(e T) bool {
+				return e.(int) > 3
+			}}}, 9},
+		{"lastwhere#6", From(span(1, 9)),
+			args{[]func(T) bool{func(e T) bool {
+				return e.(int) < 4
+			}}}, 3},
+		{"lastwhere#7", From(span(1, 9)), args{[]func(T) bool{nil}}, 9},
+		{"lastwhere#8", From(span(1, 9)), args{[]func(T) bool{nil, truth(false)}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.LastWhere(tt.args.f...); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Query.LastWhere() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_Lead(t *testing.T) {
+	type args struct {
+		offset int
+		fill   T
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want *Query
+	}{
+		{"lead#1", From([]T{}), args{1, 0}, From([]T{})},
+		{"lead#2", From(span(1, 5)), args{1, 0}, From([]T{2, 3, 4, 5, 0})},
+		{"lead#3", From(span(1, 5)), args{2, nil}, From([]T{3, 4, 5, nil, nil})},
+		{"lead#4", From(span(1, 5)), args{5, 0}, From([]T{0, 0, 0, 0, 0})},
+		{"lead#5", From(span(1, 3)), args{10, 0}, From([]T{0, 0, 0})},
+		{"lead#6", From(span(1, 5)), args{0, 0}, From(span(1, 5))},
+		{"lead#7", From(span(1, 5)), args{-1, 0}, From(span(1, 5))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.Lead(tt.args.offset, tt.args.fill); !got.equal(tt.want) {
+				t.Errorf("Query.Lead() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_LeftJoin(t *testing.T) {
+	keySel := func(e T) interface{} {
+		return e
+	}
+	resultSel := func(o, i interface{}) interface{} {
+		return []T{o, i}
+	}
+
+	type args struct {
+		inner     *Query
+		outKeySel func(T) interface{}
+		innKeySel func(T) interface{}
+		resultSel func(o, i interface{}) interface{}
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want *Query
+	}{
+		{"leftjoin#1", From([]T{}), args{From([]T{}), nil, nil, nil}, From([]T{})},
+		{"leftjoin#2", From([]T{}), args{From(span(6, 9)), keySel, keySel, resultSel}, From([]T{})},
+		{"leftjoin#3", From(span(1, 2)), args{From([]T{}), keySel, keySel, resultSel}, From([]T{[]T{1, nil}, []T{2, nil}})},
+		{"leftjoin#4", From(span(1, 5)), args{From(span(3, 9)), keySel, keySel, resultSel},
+			From([]T{[]T{1, nil}, []T{2, nil}, []T{3, 3}, []T{4, 4}, []T{5, 5}})},
+		{"leftjoin#5", From(span(1, 3)), args{From([]T{2, 2}), keySel, keySel, resultSel},
+			From([]T{[]T{1, nil}, []T{2, 2}, []T{2, 2}, []T{3, nil}})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.LeftJoin(tt.args.inner, tt.args.outKeySel, tt.args.innKeySel, tt.args.resultSel); !got.equal(tt.want) {
+				t.Errorf("Query.LeftJoin() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_LeftOuterJoin(t *testing.T) {
+	keySel := func(e T) interface{} {
+		return e
+	}
+	resultSel := func(o, i T) interface{} {
+		return []T{o, i}
+	}
+
+	type args struct {
+		inner     *Query
+		outKeySel func(T) interface{}
+		innKeySel func(T) interface{}
+		resultSel func(o, i T) interface{}
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want *Query
+	}{
+		{"leftouterjoin#1", From([]T{}), args{From([]T{}), nil, nil, nil}, From([]T{})},
+		{"leftouterjoin#2", From([]T{}), args{From(span(6, 9)), keySel, keySel, resultSel}, From([]T{})},
+		{"leftouterjoin#3", From(span(1, 2)), args{From([]T{}), keySel, keySel, resultSel}, From([]T{[]T{1, nil}, []T{2, nil}})},
+		{"leftouterjoin#4", From(span(1, 5)), args{From(span(3, 9)), keySel, keySel, resultSel},
+			From([]T{[]T{1, nil}, []T{2, nil}, []T{3, 3}, []T{4, 4}, []T{5, 5}})},
+		{"leftouterjoin#5", From(span(1, 3)), args{From([]T{2, 2}), keySel, keySel, resultSel},
+			From([]T{[]T{1, nil}, []T{2, 2}, []T{2, 2}, []T{3, nil}})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.LeftOuterJoin(tt.args.inner, tt.args.outKeySel, tt.args.innKeySel, tt.args.resultSel); !got.equal(tt.want) {
+				t.Errorf("Query.LeftOuterJoin() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_LeftOuterJoin_authors(t *testing.T) {
+	authors := []T{
+		Author{1, "Austen, Jane"},
+		Author{2, "Brontë, Emily"},
+		Author{3, "Hunter, Rachel"},
+	}
+	author2Books := []T{
+		AuthorBook{1, 4},
+		AuthorBook{3, 13},
+		AuthorBook{1, 5},
+	}
+	got := From(authors).LeftOuterJoin(From(author2Books),
+		func(e T) interface{} {
+			return e.(Author).AuthorID
+		}, func(e T) interface{} {
+			return e.(AuthorBook).AuthorID
+		}, func(o, i T) interface{} {
+			if i == nil {
+				return NameBookID{o.(Author).Name, 0}
+			}
+			return NameBookID{o.(Author).Name, i.(AuthorBook).BookID}
+		})
+	want := []interface{}{
+		NameBookID{"Austen, Jane", 4},
+		NameBookID{"Austen, Jane", 5},
+		NameBookID{"Brontë, Emily", 0},
+		NameBookID{"Hunter, Rachel", 13},
+	}
+	if v := ToSlice(got); !reflect.DeepEqual(v, want) {
+		t.Errorf("Query.LeftOuterJoin() = %v, want %v", v, want)
+	}
+}
+
+func TestQuery_MapTo(t *testing.T) {
+	type args struct {
+		f func(e T) T
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want *Query
+	}{
+		{"mapto#1", From([]T{}), args{}, From([]T{})},
+		{"mapto#2", From([]T{}), args{func(e T) T { return e.(int) + 10 }}, From([]T{})},
+		{"mapto#3", From([]T{1, 2, 3, 4, 5}), args{func(e T) T { return e.(int) + 10 }}, From([]T{11, 12, 13, 14, 15})},
+		{"mapto#4", From(span(1, 3)), args{}, From(span(1, 3))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.MapTo(tt.args.f); !got.equal(tt.want) {
+				t.Errorf("Query.MapTo() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_MapToIndexed(t *testing.T) {
+	rank := func(i int, e T) T {
+		return i*100 + e.(int)
+	}
+
+	type args struct {
+		f func(i int, e T) T
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want *Query
+	}{
+		{"maptoindexed#1", From([]T{}), args{rank}, From([]T{})},
+		{"maptoindexed#2", From(span(1, 3)), args{rank}, From([]T{1, 102, 203})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.q.MapToIndexed(tt.args.f)
+			for i := 0; i < 2; i++ {
+				if !got.equal(tt.want) {
+					t.Errorf("Query.MapToIndexed() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestQuery_MapToParallel(t *testing.T) {
+	add := func(e T) T {
+		return e.(int) + 10
+	}
+
+	type args struct {
+		workers int
+		f       func(e T) T
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want *Query
+	}{
+		{"maptoparallel#1", From([]T{}), args{4, add}, From([]T{})},
+		{"maptoparallel#2", From(span(1, 100)), args{4, add}, From(span(1, 100)).MapTo(add)},
+		{"maptoparallel#3", From(span(1, 100)), args{1, add}, From(span(1, 100)).MapTo(add)},
+		{"maptoparallel#4", From(span(1, 100)), args{0, add}, From(span(1, 100)).MapTo(add)},
+		{"maptoparallel#5", From(span(1, 3)), args{100, add}, From([]T{11, 12, 13})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.MapToParallel(tt.args.workers, tt.args.f); !got.equal(tt.want) {
+				t.Errorf("Query.MapToParallel() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_MapToRetry(t *testing.T) {
+	errFlaky := errors.New("flaky")
+
+	// flaky fails the first n calls for every element.
+	flaky := func(n int) func(e T) (T, error) {
+		calls := map[T]int{}
+		return func(e T) (T, error) {
+			calls[e]++
+			if calls[e] <= n {
+				return nil, errFlaky
+			}
+			return e.(int) * 10, nil
+		}
+	}
+
+	type args struct {
+		attempts int
+		f        func(e T) (T, error)
+	}
+	tests := []struct {
+		name    string
+		q       *Query
+		args    args
+		want    *Query
+		wantErr bool
+	}{
+		{"maptoretry#1", From([]T{}), args{3, flaky(5)}, From([]T{}), false},
+		{"maptoretry#2", From(span(1, 3)), args{1, flaky(0)}, From([]T{10, 20, 30}), false},
+		{"maptoretry#3", From(span(1, 3)), args{2, flaky(1)}, From([]T{10, 20, 30}), false},
+		{"maptoretry#4", From(span(1, 3)), args{2, flaky(2)}, nil, true},
+		{"maptoretry#5", From(span(1, 3)), args{0, flaky(1)}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.q.MapToRetry(tt.args.attempts, time.Millisecond, tt.args.f)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Query.MapToRetry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !errors.Is(err, errFlaky) {
+					t.Errorf("Query.MapToRetry() error = %v, want %v", err, errFlaky)
+				}
+				return
+			}
+			if !got.equal(tt.want) {
+				t.Errorf("Query.MapToRetry() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_Materialize(t *testing.T) {
+	tests := []struct {
+		name string
+		q    *Query
+		want *Query
+	}{
+		{"materialize#1", From([]T{}), From([]T{})},
+		{"materialize#2", From(span(1, 9)), From(span(1, 9))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.Materialize(); !got.equal(tt.want) {
+				t.Errorf("Query.Materialize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_Materialize_snapshot(t *testing.T) {
+	a := span(1, 3)
+	calls := 0
+	q := From(a).Tap(func(e T) {
+		calls++
+	})
+	got := q.Materialize()
+	a[0] = 99
+	want := From(span(1, 3))
+	if !got.equal(want) || !got.equal(want) {
+		t.Errorf("Query.Materialize() = %v, want %v", got, want)
+	}
+	if calls != 3 {
+		t.Errorf("Query.Materialize() iterated source %v elements, want 3", calls)
+	}
+}
+
+func TestQuery_MaxBy(t *testing.T) {
+	books := []T{
+		Book{2, "Pride & Prejudice", 1813},
+		Book{5, "Persuasion", 1817},
+		Book{1, "Sense & Sensibility", 1811},
+		Book{6, "Northanger Abbey", 1817},
+		Book{14, "The Schoolmistress", 1811},
+	}
+	year := func(e T) interface{} {
+		return e.(Book).Year
+	}
+	lessInt := func(a, b interface{}) bool {
+		return a.(int) < b.(int)
+	}
+
+	tests := []struct {
+		name string
+		q    *Query
+		want T
+	}{
+		{"maxby#1", From([]T{}), nil},
+		{"maxby#2", From(books[:1]), Book{2, "Pride & Prejudice", 1813}},
+		{"maxby#3", From(books), Book{5, "Persuasion", 1817}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.MaxBy(year, lessInt); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Query.MaxBy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_MaxByKeyMap(t *testing.T) {
+	books := []T{
+		Book{1, "Sense & Sensibility", 1811},
+		Book{2, "Pride & Prejudice", 1813},
+		Book{5, "Persuasion", 1817},
+		Book{6, "Northanger Abbey", 1817},
+		Book{7, "Sanditon", 1817},
+		Book{14, "The Schoolmistress", 1811},
+	}
+	byYear := func(e T) interface{} {
+		return e.(Book).Year
+	}
+	byID := func(a, b T) bool {
+		return a.(Book).BookID < b.(Book).BookID
+	}
+	byNothing := func(a, b T) bool {
+		return false
+	}
+
+	type args struct {
+		keySel func(e T) interface{}
+		less   func(a, b T) bool
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want map[interface{}]T
+	}{
+		{"maxbykeymap#1", From([]T{}), args{byYear, byID}, map[interface{}]T{}},
+		{"maxbykeymap#2", From(books), args{byYear, byID}, map[interface{}]T{
+			1811: Book{14, "The Schoolmistress", 1811},
+			1813: Book{2, "Pride & Prejudice", 1813},
+			1817: Book{7, "Sanditon", 1817},
+		}},
+		{"maxbykeymap#3", From(books), args{byYear, byNothing}, map[interface{}]T{
+			1811: Book{1, "Sense & Sensibility", 1811},
+			1813: Book{2, "Pride & Prejudice", 1813},
+			1817: Book{5, "Persuasion", 1817},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.MaxByKeyMap(tt.args.keySel, tt.args.less); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Query.MaxByKeyMap() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	type args struct {
+		queries []*Query
+	}
+	tests := []struct {
+		name string
+		args args
+		want *Query
+	}{
+		{"merge#1", args{}, From([]T{})},
+		{"merge#2", args{[]*Query{From([]T{})}}, From([]T{})},
+		{"merge#3", args{[]*Query{From([]T{1}), From([]T{2, 3}), From([]T{})}}, From(span(1, 3))},
+		{"merge#4", args{[]*Query{From([]T{}), From(span(1, 3)), From([]T{}), From(span(4, 9))}}, From(span(1, 9))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Merge(tt.args.queries...); !got.equal(tt.want) {
+				t.Errorf("Merge() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMerge_infinite(t *testing.T) {
+	want := From([]T{1, 2, 3, 1, 2, 3})
+	if got := Merge(From(span(1, 3)), naturals()).Take(6); !got.equal(want) {
+		t.Errorf("Merge() = %v, want %v", got, want)
+	}
+}
+
+func TestMergeConcurrent(t *testing.T) {
+	type args struct {
+		queries []*Query
+	}
+	tests := []struct {
+		name string
+		args args
+		want *Query
+	}{
+		{"mergeconcurrent#1", args{}, From([]T{})},
+		{"mergeconcurrent#2", args{[]*Query{From([]T{})}}, From([]T{})},
+		{"mergeconcurrent#3", args{[]*Query{From([]T{1}), From([]T{2, 3}), From([]T{})}}, From(span(1, 3))},
+		{"mergeconcurrent#4", args{[]*Query{From(span(1, 500)), From(span(501, 1000)), From(span(1001, 1500))}}, From(span(1, 1500))},
+		{"mergeconcurrent#5", args{[]*Query{From(span(1, 9)), From(span(1, 9))}}, From(span(1, 9)).Expand(duplicate)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := MergeConcurrent(tt.args.queries...)
+			if !got.Sort(less).equal(tt.want.Sort(less)) {
+				t.Errorf("MergeConcurrent() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeConcurrent_stop(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		if got := MergeConcurrent(naturals(), naturals()).First(); got != 1 {
+			t.Fatalf("MergeConcurrent().First() = %v, want 1", got)
+		}
+	}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if runtime.NumGoroutine() <= before {
+			return
+		}
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("MergeConcurrent() left %v goroutines running", runtime.NumGoroutine()-before)
+}
+
+func TestMergeConcurrent_order(t *testing.T) {
+	got := MergeConcurrent(From(span(1, 500)), From(span(501, 1000))).Cache()
+	for _, want := range []*Query{From(span(1, 500)), From(span(501, 1000))} {
+		first, last := want.First().(int), want.Last().(int)
+		inRange := func(e T) bool {
+			return e.(int) >= first && e.(int) <= last
+		}
+		if v := got.Where(inRange); !v.equal(want) {
+			t.Errorf("MergeConcurrent() = %v, want order %v", v, want)
+		}
+	}
+}
+
+func TestQuery_MergeSorted(t *testing.T) {
+	type args struct {
+		other *Query
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want *Query
+	}{
+		{"mergesorted#1", From([]T{}), args{From([]T{})}, From([]T{})},
+		{"mergesorted#2", From(span(1, 3)), args{From([]T{})}, From(span(1, 3))},
+		{"mergesorted#3", From([]T{}), args{From(span(1, 3))}, From(span(1, 3))},
+		{"mergesorted#4", From([]T{1, 3, 5, 7, 9}), args{From([]T{2, 4, 6, 8})}, From(span(1, 9))},
+		{"mergesorted#5", From([]T{2, 4, 6, 8}), args{From([]T{1, 3, 5, 7, 9})}, From(span(1, 9))},
+		{"mergesorted#6", From(span(1, 3)), args{From(span(4, 6))}, From(span(1, 6))},
+		{"mergesorted#7", From([]T{1, 2, 2}), args{From([]T{2, 3})}, From([]T{1, 2, 2, 2, 3})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.MergeSorted(tt.args.other, less); !got.equal(tt.want) {
+				t.Errorf("Query.MergeSorted() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_MergeSorted_stable(t *testing.T) {
+	byYear := func(a, b T) bool {
+		return a.(Book).Year < b.(Book).Year
+	}
+	q := From([]T{Book{1, "Sense & Sensibility", 1811}, Book{4, "Emma", 1815}})
+	other := From([]T{Book{14, "The Schoolmistress", 1811}})
+	want := From([]T{Book{1, "Sense & Sensibility", 1811}, Book{14, "The Schoolmistress", 1811}, Book{4, "Emma", 1815}})
+	if got := q.MergeSorted(other, byYear); !got.equal(want) {
+		t.Errorf("Query.MergeSorted() = %v, want %v", got, want)
+	}
+}
+
+func TestQuery_MinBy(t *testing.T) {
+	books := []T{
+		Book{2, "Pride & Prejudice", 1813},
+		Book{5, "Persuasion", 1817},
+		Book{1, "Sense & Sensibility", 1811},
+		Book{6, "Northanger Abbey", 1817},
+		Book{14, "The Schoolmistress", 1811},
+	}
+	year := func(e T) interface{} {
+		return e.(Book).Year
+	}
+	lessInt := func(a, b interface{}) bool {
+		return a.(int) < b.(int)
+	}
+
+	tests := []struct {
+		name string
+		q    *Query
+		want T
+	}{
+		{"minby#1", From([]T{}), nil},
+		{"minby#2", From(books[:1]), Book{2, "Pride & Prejudice", 1813}},
+		{"minby#3", From(books), Book{1, "Sense & Sensibility", 1811}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.MinBy(year, lessInt); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Query.MinBy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_MinMax(t *testing.T) {
+	tests := []struct {
+		name    string
+		q       *Query
+		wantMin T
+		wantMax T
+	}{
+		{"minmax#1", From([]T{}), nil, nil},
+		{"minmax#2", From([]T{5}), 5, 5},
+		{"minmax#3", From(shuffle(span(1, 9))), 1, 9},
+		{"minmax#4", From([]T{3, 3, 1, 1}), 1, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			min, max := tt.q.MinMax(less)
+			if !reflect.DeepEqual(min, tt.wantMin) || !reflect.DeepEqual(max, tt.wantMax) {
+				t.Errorf("Query.MinMax() = %v, %v, want %v, %v", min, max, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+}
+
+func TestQuery_Mode(t *testing.T) {
+	tests := []struct {
+		name string
+		q    *Query
+		want T
+	}{
+		{"mode#1", From([]T{}), nil},
+		{"mode#2", From([]T{1, 2, 2, 3, 3, 3}), 3},
+		{"mode#3", From([]T{1, 2, 2, 1}), 1},
+		{"mode#4", From([]T{2, 1, 1, 2}), 2},
+		{"mode#5", From(span(1, 9)), 1},
+		{"mode#6", From([]T{[]int{1}, []int{2}, []int{2}}), []int{2}},
+		{"mode#7", From([]T{[]T{1}, "[1]", "[1]"}), "[1]"},
+		{"mode#8", From([]T{"[1]", []T{1}, []T{1}}), []T{1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.Mode(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Query.Mode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_None(t *testing.T) {
+	isFive := func(e T) bool {
+		return e == 5
+	}
+	type args struct {
+		f []func(T) bool
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want bool
+	}{
+		{"none#1", From([]T{}), args{}, true},
+		{"none#2", From([]T{}), args{[]func(T) bool{truth(true), truth(true)}}, true},
+		{"none#3", From(span(1, 9)), args{}, false},
+		{"none#4", From(span(1, 9)), args{[]func(T) bool{truth(false), truth(false)}}, true},
+		{"none#5", From(span(1, 9)), args{[]func(T) bool{truth(false), truth(true)}}, true},
+		{"none#6", From(span(1, 9)), args{[]func(T) bool{truth(true), truth(true)}}, false},
+		{"none#7", From(span(1, 9)), args{[]func(T) bool{isFive}}, false},
+		{"none#8", naturals(), args{[]func(T) bool{isFive}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.None(tt.args.f...); got != tt.want {
+				t.Errorf("Query.None() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_Normalize(t *testing.T) {
+	tests := []struct {
+		name string
+		q    *Query
+		want *Query
+	}{
+		{"normalize#1", From([]T{}), From([]T{})},
+		{"normalize#2", From([]T{0, 5, 10}), From([]T{0.0, 0.5, 1.0})},
+		{"normalize#3", From([]T{10, 0, 5}), From([]T{1.0, 0.0, 0.5})},
+		{"normalize#4", From([]T{-1.5, 0.5, 2.5}), From([]T{0.0, 0.5, 1.0})},
+		{"normalize#5", From([]T{2, 2.0, 2}), From([]T{0.0, 0.0, 0.0})},
+		{"normalize#6", From([]T{7}), From([]T{0.0})},
+		{"normalize#7", From([]T{int64(0), float32(5), uint8(10)}), From([]T{0.0, 0.5, 1.0})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.q.Normalize()
+			if !reflect.DeepEqual(ToSlice(got), ToSlice(tt.want)) {
+				t.Errorf("Query.Normalize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_Normalize_panic(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("Query.Normalize() did not panic on non-numeric element")
+		}
+	}()
+	ToSlice(From([]T{1, "2", 3}).Normalize())
+}
+
+func TestQuery_OfType(t *testing.T) {
+	type args struct {
+		sample T
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want *Query
+	}{
+		{"oftype#1", From([]T{}), args{0}, From([]T{})},
+		{"oftype#2", From([]T{1, "a", 2, "b", 3.0}), args{0}, From([]T{1, 2})},
+		{"oftype#3", From([]T{1, "a", 2, "b", 3.0}), args{""}, From([]T{"a", "b"})},
+		{"oftype#4", From([]T{1, "a", 2, "b", 3.0}), args{true}, From([]T{})},
+		{"oftype#5", From([]T{1, nil, 2}), args{}, From([]T{nil})},
+		{"oftype#6", From([]T{Book{1, "Emma", 1815}, Author{1, "Austen, Jane"}}), args{Book{}}, From([]T{Book{1, "Emma", 1815}})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.OfType(tt.args.sample); !got.equal(tt.want) {
+				t.Errorf("Query.OfType() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_OrderBy(t *testing.T) {
+	books := []T{
+		AuthorTitleYear{"Hunter, Rachel", "The Schoolmistress", 1811},
+		AuthorTitleYear{"Austen, Jane", "Emma", 1815},
+		AuthorTitleYear{"Austen, Jane", "Sense & Sensibility", 1811},
+		AuthorTitleYear{"Hunter, Rachel", "Family Annals", 1807},
+		AuthorTitleYear{"Austen, Jane", "Pride & Prejudice", 1813},
+	}
+	byYearDesc := func(e1, e2 T) bool {
+		return e1.(AuthorTitleYear).Year > e2.(AuthorTitleYear).Year
+	}
+	byAuthor := func(e1, e2 T) bool {
+		return e1.(AuthorTitleYear).Author < e2.(AuthorTitleYear).Author
+	}
+	byTitle := func(e1, e2 T) bool {
+		return e1.(AuthorTitleYear).Title < e2.(AuthorTitleYear).Title
+	}
+
+	want := From(books).Sort(byYearDesc, byAuthor)
+	if got := From(books).OrderBy(byYearDesc).ThenBy(byAuthor).Query(); !got.equal(want) {
+		t.Errorf("Query.OrderBy().ThenBy() = %v, want %v", got, want)
+	}
+	want = From(books).Sort(byAuthor)
+	if got := From(books).OrderBy(byAuthor).Query(); !got.equal(want) {
+		t.Errorf("Query.OrderBy() = %v, want %v", got, want)
+	}
+
+	// ThenBy must not alter the ordering it extends:
+	s := From(books).OrderBy(byAuthor)
+	byAuthorYear := s.ThenBy(byYearDesc)
+	byAuthorTitle := s.ThenBy(byTitle)
+	want = From(books).Sort(byAuthor, byYearDesc)
+	if got := byAuthorYear.Query(); !got.equal(want) {
+		t.Errorf("Query.OrderBy().ThenBy() = %v, want %v", got, want)
+	}
+	want = From(books).Sort(byAuthor, byTitle)
+	if got := byAuthorTitle.Query(); !got.equal(want) {
+		t.Errorf("Query.OrderBy().ThenBy() = %v, want %v", got, want)
+	}
+	if got := From([]T{}).OrderBy(byAuthor).ThenBy(byTitle).Query(); !got.equal(From([]T{})) {
+		t.Errorf("Query.OrderBy().ThenBy() = %v, want []", got)
+	}
+}
+
+func TestQuery_Pad(t *testing.T) {
+	type args struct {
+		length int
+		fill   T
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want *Query
+	}{
+		{"pad#1", From([]T{}), args{2, 0}, From([]T{0, 0})},
+		{"pad#2", From(span(1, 3)), args{5, 0}, From([]T{1, 2, 3, 0, 0})},
+		{"pad#3", From(span(1, 3)), args{3, 0}, From(span(1, 3))},
+		{"pad#4", From(span(1, 3)), args{1, 0}, From(span(1, 3))},
+		{"pad#5", From(span(1, 3)), args{-1, 0}, From(span(1, 3))},
+		{"pad#6", From(span(1, 3)), args{4, nil}, From([]T{1, 2, 3, nil})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.Pad(tt.args.length, tt.args.fill); !got.equal(tt.want) {
+				t.Errorf("Query.Pad() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_PadLeft(t *testing.T) {
+	type args struct {
+		length int
+		fill   T
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want *Query
+	}{
+		{"padleft#1", From([]T{}), args{2, 0}, From([]T{0, 0})},
+		{"padleft#2", From(span(1, 3)), args{5, 0}, From([]T{0, 0, 1, 2, 3})},
+		{"padleft#3", From(span(1, 3)), args{3, 0}, From(span(1, 3))},
+		{"padleft#4", From(span(1, 3)), args{1, 0}, From(span(1, 3))},
+		{"padleft#5", From(span(1, 3)), args{-1, 0}, From(span(1, 3))},
+		{"padleft#6", From(span(1, 3)), args{4, nil}, From([]T{nil, 1, 2, 3})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.PadLeft(tt.args.length, tt.args.fill); !got.equal(tt.want) {
+				t.Errorf("Query.PadLeft() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_Pairwise(t *testing.T) {
+	tests := []struct {
+		name string
+		q    *Query
+		want *Query
+	}{
+		{"pairwise#1", From([]T{}), From([]T{})},
+		{"pairwise#2", From([]T{1}), From([]T{})},
+		{"pairwise#3", From(span(1, 2)), From([]T{[]T{1, 2}})},
+		{"pairwise#4", From(span(1, 4)), From([]T{[]T{1, 2}, []T{2, 3}, []T{3, 4}})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.Pairwise(); !got.equal(tt.want) {
+				t.Errorf("Query.Pairwise() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_Partition(t *testing.T) {
+	isEven := func(e T) bool {
+		return e.(int)%2 == 0
+	}
+
+	type args struct {
+		f func(T) bool
+	}
+	tests := []struct {
+		name        string
+		q           *Query
+		args        args
+		wantMatched *Query
+		wantRest    *Query
+	}{
+		{"partition#1", From([]T{}), args{isEven}, From([]T{}), From([]T{})},
+		{"partition#2", From(span(1, 9)), args{truth(true)}, From(span(1, 9)), From([]T{})},
+		{"partition#3", From(span(1, 9)), args{truth(false)}, From([]T{}), From(span(1, 9))},
+		{"partition#4", From(span(1, 9)), args{isEven}, From([]T{2, 4, 6, 8}), From([]T{1, 3, 5, 7, 9})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matched, rest := tt.q.Partition(tt.args.f)
+			if !matched.equal(tt.wantMatched) {
+				t.Errorf("Query.Partition() matched = %v, want %v", matched, tt.wantMatched)
+			}
+			if !rest.equal(tt.wantRest) {
+				t.Errorf("Query.Partition() rest = %v, want %v", rest, tt.wantRest)
+			}
+		})
+	}
+}
+
+func TestQuery_PartitionByWeight(t *testing.T) {
+	weight := func(e T) float64 {
+		return float64(e.(int))
+	}
+
+	type args struct {
+		n int
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want []*Query
+	}{
+		{"partitionbyweight#1", From(span(1, 9)), args{0}, nil},
+		{"partitionbyweight#2", From(span(1, 9)), args{-1}, nil},
+		{"partitionbyweight#3", From([]T{}), args{2}, []*Query{From([]T{}), From([]T{})}},
+		{"partitionbyweight#4", From(span(1, 9)), args{1}, []*Query{From(span(9, 1))}},
+		{"partitionbyweight#5", From(span(1, 9)), args{3}, []*Query{From([]T{9, 4, 3}), From([]T{8, 5, 2}), From([]T{7, 6, 1})}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.q.PartitionByWeight(tt.args.n, weight)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Query.PartitionByWeight() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if !got[i].equal(tt.want[i]) {
+					t.Errorf("Query.PartitionByWeight()[%d] = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestQuery_PartitionByWeight_balanced(t *testing.T) {
+	weight := func(e T) float64 {
+		return float64(e.(int))
+	}
+	a := shuffle(span(1, 100))
+	parts := From(a).PartitionByWeight(4, weight)
+
+	seen := map[T]int{}
+	min, max := 0.0, 0.0
+	for i, p := range parts {
+		total := 0.0
+		p.ForEach(func(e T) {
+			seen[e]++
+			total += weight(e)
+		})
+		if i == 0 || total < min {
+			min = total
+		}
+		if i == 0 || total > max {
+			max = total
+		}
+	}
+	for _, e := range a {
+		if seen[e] != 1 {
+			t.Errorf("Query.PartitionByWeight() element %v seen %v times, want 1", e, seen[e])
+		}
+	}
+	if max-min > 100 {
+		t.Errorf("Query.PartitionByWeight() weights range [%v, %v], want difference <= 100", min, max)
+	}
+}
+
+func TestQuery_Peekable(t *testing.T) {
+	type step struct {
+		peek bool
+		elem T
+		ok   bool
+	}
+	tests := []struct {
+		name  string
+		q     *Query
+		steps []step
+	}{
+		{"peekable#1", From([]T{}), []step{{true, nil, false}, {false, nil, false}}},
+		{"peekable#2", From(span(1, 3)), []step{
+			{true, 1, true}, {true, 1, true}, {false, 1, true},
+			{false, 2, true}, {true, 3, true}, {true, 3, true},
+			{false, 3, true}, {true, nil, false}, {false, nil, false},
+		}},
+		{"peekable#3", From(span(1, 2)), []step{
+			{false, 1, true}, {false, 2, true}, {false, nil, false}, {true, nil, false},
+		}},
+		{"peekable#4", naturals(), []step{
+			{true, 1, true}, {false, 1, true}, {true, 2, true}, {true, 2, true},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.q.Peekable()
+			for i, s := range tt.steps {
+				var elem T
+				var ok bool
+				if s.peek {
+					elem, ok = p.Peek()
+				} else {
+					elem, ok = p.Next()
+				}
+				if elem != s.elem || ok != s.ok {
+					t.Errorf("Peeker step %d = (%v, %v), want (%v, %v)", i, elem, ok, s.elem, s.ok)
+				}
+			}
+		})
+	}
+}
+
+func TestQuery_Percentile(t *testing.T) {
+	type args struct {
+		p float64
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want T
+	}{
+		{"percentile#1", From([]T{}), args{50}, nil},
+		{"percentile#2", From(shuffle(span(1, 9))), args{50}, 5},
+		{"percentile#3", From(shuffle(span(1, 9))), args{100}, 9},
+		{"percentile#4", From(shuffle(span(1, 9))), args{0}, 1},
+		{"percentile#5", From(shuffle(span(1, 10))), args{90}, 9},
+		{"percentile#6", From(shuffle(span(1, 10))), args{91}, 10},
+		{"percentile#7", From(shuffle(span(1, 9))), args{-10}, 1},
+		{"percentile#8", From(shuffle(span(1, 9))), args{200}, 9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.Percentile(tt.args.p, less); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Query.Percentile() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_Prepend(t *testing.T) {
+	type args struct {
+		e T
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want *Query
+	}{
+		{"prepend#1", From([]T{}), args{}, From([]T{nil})},
+		{"prepend#2", From([]T{}), args{0}, From([]T{0})},
+		{"prepend#3", From(span(1, 3)), args{0}, From(span(0, 3))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.Prepend(tt.args.e); !got.equal(tt.want) {
+				t.Errorf("Query.Prepend() = %v, want %v", got, tt.want)
+			}
+			if got := tt.q.Prepend(tt.args.e); !got.equal(got) {
+				t.Errorf("Query.Prepend() = %v, not re-iterable", got)
+			}
+		})
+	}
+}
+
+func TestQuery_Product(t *testing.T) {
+	tests := []struct {
+		name string
+		q    *Query
+		want interface{}
+	}{
+		{"product#1", From([]T{}), 1},
+		{"product#2", From([]T{7}), 7},
+		{"product#3", From(span(1, 5)), 120},
+		{"product#4", From(span(0, 5)), 0},
+		{"product#5", From([]T{-2, 3}), -6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.Product(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Query.Product() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_Reduce(t *testing.T) {
+	type args struct {
+		f func(v T, e T) interface{}
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want interface{}
+	}{
+		{"reduce#1", From([]T{}), args{}, nil},
+		{"reduce#2", From([]T{}), args{sum}, nil},
+		{"reduce#3", From([]T{1}), args{sum}, 1},
+		{"reduce#4", From(span(1, 9)), args{sum}, 45},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.Reduce(tt.args.f); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Query.Reduce() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_ReduceOK(t *testing.T) {
+	nothing := func(v, e T) interface{} {
+		return nil
+	}
+	type args struct {
+		f func(v T, e T) interface{}
+	}
+	tests := []struct {
+		name   string
+		q      *Query
+		args   args
+		want   interface{}
+		wantOK bool
+	}{
+		{"reduceok#1", From([]T{}), args{sum}, nil, false},
+		{"reduceok#2", From([]T{0}), args{sum}, 0, true},
+		{"reduceok#3", From([]T{nil}), args{sum}, nil, true},
+		{"reduceok#4", From(span(1, 3)), args{nothing}, nil, true},
+		{"reduceok#5", From(span(1, 9)), args{sum}, 45, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.q.ReduceOK(tt.args.f)
+			if !reflect.DeepEqual(got, tt.want) || ok != tt.wantOK {
+				t.Errorf("Query.ReduceOK() = (%v, %v), want (%v, %v)", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestQuery_ReduceRight(t *testing.T) {
+	sub := func(e, acc T) interface{} {
+		return e.(int) - acc.(int)
+	}
+	type args struct {
+		f func(e T, acc T) interface{}
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want interface{}
+	}{
+		{"reduceright#1", From([]T{}), args{}, nil},
+		{"reduceright#2", From([]T{}), args{sub}, nil},
+		{"reduceright#3", From([]T{1}), args{sub}, 1},
+		{"reduceright#4", From(span(1, 3)), args{sub}, 1 - (2 - 3)},
+		{"reduceright#5", From(span(1, 9)), args{sum}, 45},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.ReduceRight(tt.args.f); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Query.ReduceRight() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_RemoveAt(t *testing.T) {
+	type args struct {
+		i int
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want *Query
+	}{
+		{"removeat#1", From([]T{}), args{0}, From([]T{})},
+		{"removeat#2", From(span(1, 5)), args{2}, From([]T{1, 2, 4, 5})},
+		{"removeat#3", From(span(1, 5)), args{0}, From([]T{2, 3, 4, 5})},
+		{"removeat#4", From(span(1, 5)), args{4}, From([]T{1, 2, 3, 4})},
+		{"removeat#5", From(span(1, 5)), args{5}, From(span(1, 5))},
+		{"removeat#6", From(span(1, 5)), args{-1}, From(span(1, 5))},
+		{"removeat#7", From([]T{1}), args{0}, From([]T{})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.RemoveAt(tt.args.i); !got.equal(tt.want) {
+				t.Errorf("Query.RemoveAt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_Replace(t *testing.T) {
+	type args struct {
+		old   T
+		new   T
+		limit int
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want *Query
+	}{
+		{"replace#1", From([]T{}), args{2, 9, 0}, From([]T{})},
+		{"replace#2", From([]T{2, 2, 2}), args{2, 9, 2}, From([]T{9, 9, 2})},
+		{"replace#3", From([]T{2, 2, 2}), args{2, 9, 0}, From([]T{9, 9, 9})},
+		{"replace#4", From([]T{2, 2, 2}), args{2, 9, -1}, From([]T{9, 9, 9})},
+		{"replace#5", From([]T{1, 2, 3, 2}), args{2, 9, 1}, From([]T{1, 9, 3, 2})},
+		{"replace#6", From(span(1, 3)), args{4, 9, 0}, From(span(1, 3))},
+		{"replace#7", From([]T{[]int{1}, 2}), args{[]int{1}, 9, 0}, From([]T{9, 2})},
+		{"replace#8", From([]T{nil, 1, nil}), args{nil, 0, 0}, From([]T{0, 1, 0})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.Replace(tt.args.old, tt.args.new, tt.args.limit); !got.equal(tt.want) {
+				t.Errorf("Query.Replace() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_RollingSumInt(t *testing.T) {
+	type args struct {
+		size int
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want *Query
+	}{
+		{"rollingsumint#1", From([]T{}), args{3}, From([]T{})},
+		{"rollingsumint#2", From(span(1, 5)), args{3}, From([]T{6, 9, 12})},
+		{"rollingsumint#3", From(span(1, 5)), args{1}, From(span(1, 5))},
+		{"rollingsumint#4", From(span(1, 5)), args{5}, From([]T{15})},
+		{"rollingsumint#5", From(span(1, 5)), args{6}, From([]T{})},
+		{"rollingsumint#6", From(span(1, 5)), args{0}, From([]T{})},
+		{"rollingsumint#7", From(span(1, 5)), args{-1}, From([]T{})},
+		{"rollingsumint#8", From(span(1, 5)), args{math.MaxInt64}, From([]T{})},
+		{"rollingsumint#9", From(span(1, 9)), args{2}, From([]T{3, 5, 7, 9, 11, 13, 15, 17})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.RollingSumInt(tt.args.size); !got.equal(tt.want) {
+				t.Errorf("Query.RollingSumInt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_RollingSumInt_panic(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("Query.RollingSumInt() did not panic on non-int element")
+		}
+	}()
+	ToSlice(From([]T{1, "2", 3}).RollingSumInt(2))
+}
+
+func TestQuery_Rotate(t *testing.T) {
+	type args struct {
+		n int
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want *Query
+	}{
+		{"rotate#1", From([]T{}), args{2}, From([]T{})},
+		{"rotate#2", From(span(1, 5)), args{2}, From([]T{3, 4, 5, 1, 2})},
+		{"rotate#3", From(span(1, 5)), args{-1}, From([]T{5, 1, 2, 3, 4})},
+		{"rotate#4", From(span(1, 5)), args{0}, From(span(1, 5))},
+		{"rotate#5", From(span(1, 5)), args{5}, From(span(1, 5))},
+		{"rotate#6", From(span(1, 5)), args{7}, From([]T{3, 4, 5, 1, 2})},
+		{"rotate#7", From(span(1, 5)), args{-6}, From([]T{5, 1, 2, 3, 4})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.Rotate(tt.args.n); !got.equal(tt.want) {
+				t.Errorf("Query.Rotate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_Sample(t *testing.T) {
+	type args struct {
+		n    int
+		seed int64
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want int
+	}{
+		{"sample#1", From([]T{}), args{3, 1}, 0},
+		{"sample#2", From(span(1, 100)), args{0, 1}, 0},
+		{"sample#3", From(span(1, 100)), args{-1, 1}, 0},
+		{"sample#4", From(span(1, 100)), args{3, 1}, 3},
+		{"sample#5", From(span(1, 100)), args{3, 42}, 3},
+		{"sample#6", From(span(1, 100)), args{50, 7}, 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.q.Sample(tt.args.n, tt.args.seed)
+			a := ToSlice(got)
+			if len(a) != tt.want {
+				t.Errorf("Query.Sample() = %v, want %v elements", got, tt.want)
+			}
+			seen := map[T]bool{}
+			for _, e := range a {
+				if seen[e] || !tt.q.Contains(e) {
+					t.Errorf("Query.Sample() = %v, element %v is duplicate or out of range", got, e)
+				}
+				seen[e] = true
+			}
+			if !got.equal(got) {
+				t.Errorf("Query.Sample() = %v, not reproducible", got)
+			}
+		})
+	}
+}
+
+func TestQuery_Sample_all(t *testing.T) {
+	for _, n := range []int{9, 10, 100} {
+		if got := From(span(1, 9)).Sample(n, 1); !got.equal(From(span(1, 9))) {
+			t.Errorf("Query.Sample(%v) = %v, want %v", n, got, span(1, 9))
+		}
+	}
+}
+
+func TestQuery_Scan(t *testing.T) {
+	max := func(v, e T) interface{} {
+		if e.(int) > v.(int) {
+			return e
+		}
+		return v
+	}
+
+	type args struct {
+		v T
+		f func(t1, t2 T) interface{}
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want *Query
+	}{
+		{"scan#1", From([]T{}), args{}, From([]T{})},
+		{"scan#2", From([]T{}), args{0, sum}, From([]T{})},
+		{"scan#3", From(span(1, 4)), args{0, sum}, From([]T{1, 3, 6, 10})},
+		{"scan#4", From(span(1, 4)), args{10, sum}, From([]T{11, 13, 16, 20})},
+		{"scan#5", From([]T{1, 3, 2, 5, 4}), args{0, max}, From([]T{1, 3, 3, 5, 5})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.Scan(tt.args.v, tt.args.f); !got.equal(tt.want) {
+				t.Errorf("Query.Scan() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_SelectMany(t *testing.T) {
+	authors := []T{
+		Author{1, "Austen, Jane"},
+		Author{2, "Brontë, Emily"},
+		Author{4, "Unknown"},
+	}
+	books := map[int][]T{
+		1: {Book{4, "Emma", 1815}, Book{5, "Persuasion", 1817}},
+		2: {Book{8, "Wuthering Heights", 1847}},
+	}
+	written := func(e T) []T {
+		return books[e.(Author).AuthorID]
+	}
+	pair := func(src, item T) interface{} {
+		return AuthorTitleYear{src.(Author).Name, item.(Book).Title, item.(Book).Year}
+	}
+	type args struct {
+		collSel   func(e T) []T
+		resultSel func(src, item T) interface{}
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want []interface{}
+	}{
+		{"selectmany#1", From([]T{}), args{written, pair}, []interface{}{}},
+		{"selectmany#2", From(authors), args{written, pair}, []interface{}{
+			AuthorTitleYear{"Austen, Jane", "Emma", 1815},
+			AuthorTitleYear{"Austen, Jane", "Persuasion", 1817},
+			AuthorTitleYear{"Brontë, Emily", "Wuthering Heights", 1847},
+		}},
+		{"selectmany#3", From(authors[2:]), args{written, pair}, []interface{}{}},
+		{"selectmany#4", From(span(1, 2)), args{duplicate, nil}, []interface{}{1, 1, 2, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ToSlice(tt.q.SelectMany(tt.args.collSel, tt.args.resultSel)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Query.SelectMany() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_Skip(t *testing.T) {
+	type args struct {
+		n int
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want *Query
+	}{
+		{"skip#1", From([]T{}), args{0}, From([]T{})},
+		{"skip#2", From([]T{}), args{5}, From([]T{})},
+		{"skip#3", From(span(1, 9)), args{0}, From(span(1, 9))},
+		{"skip#4", From(span(1, 9)), args{5}, From(span(6, 9))},
+		{"skip#5", From(span(1, 9)), args{8}, From([]T{9})},
+		{"skip#6", From(span(1, 9)), args{9}, From([]T{})},
+		{"skip#7", From(span(1, 9)), args{100}, From([]T{})},
+		{"skip#8", From(span(1, 9)), args{-100}, From([]T{})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.Skip(tt.args.n); !got.equal(tt.want) {
+				t.Errorf("Query.Skip() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_Slice(t *testing.T) {
+	type args struct {
+		start int
+		stop  int
+		step  int
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want *Query
+	}{
+		{"slice#1", From([]T{}), args{0, 5, 1}, From([]T{})},
+		{"slice#2", From(span(1, 10)), args{1, 8, 2}, From([]T{2, 4, 6, 8})},
+		{"slice#3", From(span(1, 10)), args{0, 10, 1}, From(span(1, 10))},
+		{"slice#4", From(span(1, 10)), args{0, 100, 3}, From([]T{1, 4, 7, 10})},
+		{"slice#5", From(span(1, 10)), args{5, 5, 1}, From([]T{})},
+		{"slice#6", From(span(1, 10)), args{8, 2, 1}, From([]T{})},
+		{"slice#7", From(span(1, 10)), args{0, -2, 1}, From(span(1, 8))},
+		{"slice#8", From(span(1, 10)), args{-3, 10, 1}, From([]T{8, 9, 10})},
+		{"slice#9", From(span(1, 10)), args{-100, -8, 1}, From([]T{1, 2})},
+		{"slice#10", From(span(1, 10)), args{0, -100, 1}, From([]T{})},
+		{"slice#11", From(span(1, 10)), args{0, 10, 0}, From([]T{})},
+		{"slice#12", naturals(), args{10, 20, 5}, From([]T{11, 16})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.Slice(tt.args.start, tt.args.stop, tt.args.step); !got.equal(tt.want) {
+				t.Errorf("Query.Slice() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_Sort(t *testing.T) {
+	type args struct {
+		f []func(t1, t2 T) bool
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want *Query
+	}{
+		{"sortby#1", From([]T{}), args{}, From([]T{})},
+		{"sortby#2", From([]T{1}), args{[]func(t1, t2 T) bool{less, less}}, From([]T{1})},
+		{"sortby#3", From(shuffle(span(1, 9))), args{[]func(t1, t2 T) bool{less, less}}, From(span(1, 9))},
+		{"sortby#4", From(span(9, 1)), args{[]func(t1, t2 T) bool{less, less}}, From(span(1, 9))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.Sort(tt.args.f...); !got.equal(tt.want) {
+				t.Errorf("Query.SortBy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_SortByKey(t *testing.T) {
+	identity := func(e T) interface{} {
+		return e
+	}
+	lessInt := func(a, b interface{}) bool {
+		return a.(int) < b.(int)
+	}
+	parity := func(e T) interface{} {
+		return e.(int) % 2
+	}
+
+	type args struct {
+		keySel func(e T) interface{}
+		less   func(a, b interface{}) bool
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want *Query
+	}{
+		{"sortbykey#1", From([]T{}), args{identity, lessInt}, From([]T{})},
+		{"sortbykey#2", From([]T{1}), args{identity, lessInt}, From([]T{1})},
+		{"sortbykey#3", From(shuffle(span(1, 9))), args{identity, lessInt}, From(span(1, 9))},
+		{"sortbykey#4", From(span(9, 1)), args{identity, lessInt}, From(span(1, 9))},
+		{"sortbykey#5", From(span(1, 9)), args{parity, lessInt}, From([]T{2, 4, 6, 8, 1, 3, 5, 7, 9})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.SortByKey(tt.args.keySel, tt.args.less); !got.equal(tt.want) {
+				t.Errorf("Query.SortByKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_SortByKey_selectsOnce(t *testing.T) {
+	n := 0
+	keySel := func(e T) interface{} {
+		n++
+		return e
+	}
+	lessInt := func(a, b interface{}) bool {
+		return a.(int) < b.(int)
+	}
+	ToSlice(From(shuffle(span(1, 100))).SortByKey(keySel, lessInt))
+	if n != 100 {
+		t.Errorf("Query.SortByKey() selected %v keys, want 100", n)
+	}
+}
+
+func TestQuery_SortCmp(t *testing.T) {
+	asc := func(a, b T) int {
+		return a.(int) - b.(int)
+	}
+	desc := func(a, b T) int {
+		return b.(int) - a.(int)
+	}
+	type args struct {
+		cmp func(a, b T) int
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want *Query
+	}{
+		{"sortcmp#1", From([]T{}), args{asc}, From([]T{})},
+		{"sortcmp#2", From(shuffle(span(1, 9))), args{asc}, From(span(1, 9))},
+		{"sortcmp#3", From(shuffle(span(1, 9))), args{desc}, From(span(9, 1))},
+		{"sortcmp#4", From([]T{2, 1, 2, 1}), args{asc}, From([]T{1, 1, 2, 2})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.SortCmp(tt.args.cmp); !got.equal(tt.want) {
+				t.Errorf("Query.SortCmp() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_SortCmp_stable(t *testing.T) {
+	books := []T{
+		Book{1, "Sense & Sensibility", 1811},
+		Book{2, "Pride & Prejudice", 1813},
+		Book{14, "The Schoolmistress", 1811},
+		Book{4, "Emma", 1815},
+	}
+	byYearTitle := func(a, b T) int {
+		if d := a.(Book).Year - b.(Book).Year; d != 0 {
+			return d
+		}
+		return strings.Compare(a.(Book).Title, b.(Book).Title)
+	}
+	byYear := func(a, b T) int {
+		return a.(Book).Year - b.(Book).Year
+	}
+
+	want := From([]T{books[0], books[2], books[1], books[3]})
+	if got := From(books).SortCmp(byYearTitle); !got.equal(want) {
+		t.Errorf("Query.SortCmp() = %v, want %v", got, want)
+	}
+	want = From([]T{books[0], books[2], books[1], books[3]})
+	if got := From([]T{books[2], books[0], books[1], books[3]}).SortCmp(byYearTitle); !got.equal(want) {
+		t.Errorf("Query.SortCmp() = %v, want %v", got, want)
+	}
+	want = From([]T{books[2], books[0], books[1], books[3]})
+	if got := From([]T{books[2], books[0], books[1], books[3]}).SortCmp(byYear); !got.equal(want) {
+		t.Errorf("Query.SortCmp() = %v, want %v", got, want)
+	}
+}
+
+func TestQuery_SortDescending(t *testing.T) {
+	type args struct {
+		f []func(t1, t2 T) bool
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want *Query
+	}{
+		{"sortdescending#1", From([]T{}), args{[]func(t1, t2 T) bool{less}}, From([]T{})},
+		{"sortdescending#2", From([]T{1}), args{[]func(t1, t2 T) bool{less, less}}, From([]T{1})},
+		{"sortdescending#3", From(shuffle(span(1, 9))), args{[]func(t1, t2 T) bool{less, less}}, From(span(9, 1))},
+		{"sortdescending#4", From(span(1, 9)), args{[]func(t1, t2 T) bool{less}}, From(span(9, 1))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.SortDescending(tt.args.f...); !got.equal(tt.want) {
+				t.Errorf("Query.SortDescending() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_SortDescending_stable(t *testing.T) {
+	books := []T{
+		Book{1, "Sense & Sensibility", 1811},
+		Book{2, "Pride & Prejudice", 1813},
+		Book{14, "The Schoolmistress", 1811},
+		Book{4, "Emma", 1815},
+	}
+	byYear := func(a, b T) bool {
+		return a.(Book).Year < b.(Book).Year
+	}
+	byTitle := func(a, b T) bool {
+		return a.(Book).Title < b.(Book).Title
+	}
+
+	want := From([]T{books[3], books[1], books[2], books[0]})
+	if got := From(books).SortDescending(byYear, byTitle); !got.equal(want) {
+		t.Errorf("Query.SortDescending() = %v, want %v", got, want)
+	}
+	want = From([]T{books[3], books[1], books[0], books[2]})
+	if got := From(books).SortDescending(byYear); !got.equal(want) {
+		t.Errorf("Query.SortDescending() = %v, want %v", got, want)
+	}
+}
+
+func TestBy_Sort(t *testing.T) {
+	type args struct {
+		t []interface{}
+	}
+	tests := []struct {
+		name string
+		by   by
+		args args
+	}{
+		{"sort#1", []func(t1, t2 T) bool{less, less}, args{[]interface{}{}}},
+		{"sort#2", []func(t1, t2 T) bool{less, less}, args{[]interface{}{1}}},
+		{"sort#3", []func(t1, t2 T) bool{less, less}, args{[]interface{}{1, 6, 3, 7, 5, 8, 4, 9, 2}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.by.Sort(tt.args.t)
+		})
+	}
+}
+
+func Test_sorter_Len(t *testing.T) {
+	tests := []struct {
+		name string
+		s    sorter
+		want int
+	}{
+		{"len#1", sorter{}, 0},
+		{"len#2", sorter{t: []interface{}{1}}, 1},
+		{"len#3", sorter{t: []interface{}{1, 6, 3, 7, 5, 8, 4, 9, 2}}, 9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.s.Len(); got != tt.want {
+				t.Errorf("sorter.Len() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_sorter_Swap(t *testing.T) {
+	type args struct {
+		i int
+		j int
+	}
+	tests := []struct {
+		name string
+		s    sorter
 		args args
 	}{
 		{"swap#1", sorter{t: []interface{}{1}}, args{0, 0}},
@@ -792,6 +3998,100 @@ func Test_sorter_Less(t *testing.T) {
 	}
 }
 
+func TestQuery_SplitAt(t *testing.T) {
+	type args struct {
+		i int
+	}
+	tests := []struct {
+		name     string
+		q        *Query
+		args     args
+		wantHead *Query
+		wantTail *Query
+	}{
+		{"splitat#1", From([]T{}), args{4}, From([]T{}), From([]T{})},
+		{"splitat#2", From(span(1, 9)), args{4}, From(span(1, 4)), From(span(5, 9))},
+		{"splitat#3", From(span(1, 9)), args{0}, From([]T{}), From(span(1, 9))},
+		{"splitat#4", From(span(1, 9)), args{-1}, From([]T{}), From(span(1, 9))},
+		{"splitat#5", From(span(1, 9)), args{9}, From(span(1, 9)), From([]T{})},
+		{"splitat#6", From(span(1, 9)), args{100}, From(span(1, 9)), From([]T{})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			head, tail := tt.q.SplitAt(tt.args.i)
+			if !head.equal(tt.wantHead) {
+				t.Errorf("Query.SplitAt() head = %v, want %v", head, tt.wantHead)
+			}
+			if !tail.equal(tt.wantTail) {
+				t.Errorf("Query.SplitAt() tail = %v, want %v", tail, tt.wantTail)
+			}
+		})
+	}
+}
+
+func TestQuery_StepBy(t *testing.T) {
+	type args struct {
+		n int
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want *Query
+	}{
+		{"stepby#1", From([]T{}), args{3}, From([]T{})},
+		{"stepby#2", From(span(1, 10)), args{3}, From([]T{1, 4, 7, 10})},
+		{"stepby#3", From(span(1, 10)), args{1}, From(span(1, 10))},
+		{"stepby#4", From(span(1, 10)), args{100}, From([]T{1})},
+		{"stepby#5", From(span(1, 10)), args{0}, From([]T{})},
+		{"stepby#6", From(span(1, 10)), args{-1}, From([]T{})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.StepBy(tt.args.n); !got.equal(tt.want) {
+				t.Errorf("Query.StepBy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_SumBy(t *testing.T) {
+	books := []T{
+		Book{1, "Sense & Sensibility", 1811},
+		Book{2, "Pride & Prejudice", 1813},
+		Book{3, "Mansfield Park", 1814},
+		Book{4, "Emma", 1815},
+		Book{5, "Persuasion", 1817},
+	}
+	year := func(e T) int {
+		return e.(Book).Year
+	}
+	identity := func(e T) int {
+		return e.(int)
+	}
+	type args struct {
+		sel func(e T) int
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want int
+	}{
+		{"sumby#1", From([]T{}), args{year}, 0},
+		{"sumby#2", From(books), args{year}, 1811 + 1813 + 1814 + 1815 + 1817},
+		{"sumby#3", From(span(1, 9)), args{identity}, 45},
+		{"sumby#4", From([]T{-1, 1}), args{identity}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.SumBy(tt.args.sel); got != tt.want {
+				t.Errorf("Query.SumBy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestQuery_Take(t *testing.T) {
 	type args struct {
 		n int
@@ -819,6 +4119,116 @@ func TestQuery_Take(t *testing.T) {
 	}
 }
 
+func TestQuery_TakeLast(t *testing.T) {
+	type args struct {
+		n int
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want *Query
+	}{
+		{"takelast#1", From([]T{}), args{0}, From([]T{})},
+		{"takelast#2", From(span(1, 9)), args{0}, From([]T{})},
+		{"takelast#3", From([]T{}), args{5}, From([]T{})},
+		{"takelast#4", From(span(1, 9)), args{3}, From(span(7, 9))},
+		{"takelast#5", From(span(1, 9)), args{9}, From(span(1, 9))},
+		{"takelast#6", From(span(1, 9)), args{100}, From(span(1, 9))},
+		{"takelast#7", From(span(1, 9)), args{-100}, From([]T{})},
+		{"takelast#8", From(span(1, 3)), args{math.MaxInt64}, From(span(1, 3))},
+		{"takelast#9", From(span(1, 9)), args{4}, From(span(6, 9))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.TakeLast(tt.args.n); !got.equal(tt.want) {
+				t.Errorf("Query.TakeLast() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_Tally(t *testing.T) {
+	tests := []struct {
+		name string
+		q    *Query
+		want map[T]int
+	}{
+		{"tally#1", From([]T{}), map[T]int{}},
+		{"tally#2", From([]T{1, 1, 2, 3, 3, 3}), map[T]int{1: 2, 2: 1, 3: 3}},
+		{"tally#3", From([]T{"a", 1, "a", nil}), map[T]int{"a": 2, 1: 1, nil: 1}},
+		{"tally#4", From([]T{[]int{1}, []int{1}, []int{2}}), map[T]int{fmtKey("[1]"): 2, fmtKey("[2]"): 1}},
+		{"tally#5", From([]T{Position{Value: []T{1}}, Position{Value: []T{1}}}), map[T]int{fmtKey("{false false [1]}"): 2}},
+		{"tally#6", From([]T{[]T{1}, "[1]"}), map[T]int{fmtKey("[1]"): 1, "[1]": 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.Tally(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Query.Tally() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_Tap(t *testing.T) {
+	tests := []struct {
+		name string
+		q    *Query
+		want *Query
+	}{
+		{"tap#1", From([]T{}), From([]T{})},
+		{"tap#2", From(span(1, 9)), From(span(1, 9))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen []T
+			got := tt.q.Tap(func(e T) {
+				seen = append(seen, e)
+			})
+			for i := 0; i < 2; i++ {
+				seen = nil
+				if !got.equal(tt.want) {
+					t.Errorf("Query.Tap() = %v, want %v", got, tt.want)
+				}
+				if !From(seen).equal(tt.want) {
+					t.Errorf("Query.Tap() observed %v, want %v", seen, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestQuery_Tee(t *testing.T) {
+	tests := []struct {
+		name string
+		q    *Query
+		want *Query
+	}{
+		{"tee#1", From([]T{}), From([]T{})},
+		{"tee#2", From(span(1, 9)), From(span(1, 9))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := 0
+			got1, got2 := tt.q.
+				MapTo(func(e T) T {
+					n++
+					return e
+				}).
+				Tee()
+			if !got1.Skip(1).equal(tt.want.Skip(1)) || !got1.equal(tt.want) {
+				t.Errorf("Query.Tee() first = %v, want %v", got1, tt.want)
+			}
+			if !got2.equal(tt.want) {
+				t.Errorf("Query.Tee() second = %v, want %v", got2, tt.want)
+			}
+			if want := len(ToSlice(tt.want)); n != want {
+				t.Errorf("Query.Tee() mapped %v elements, want %v", n, want)
+			}
+		})
+	}
+}
+
 func TestToSlice(t *testing.T) {
 	type args struct {
 		q *Query
@@ -841,6 +4251,157 @@ func TestToSlice(t *testing.T) {
 	}
 }
 
+func TestQuery_ToIntSlice(t *testing.T) {
+	tests := []struct {
+		name    string
+		q       *Query
+		want    []int
+		wantErr string
+	}{
+		{"tointslice#1", From([]T{}), []int{}, ""},
+		{"tointslice#2", From(span(1, 3)), []int{1, 2, 3}, ""},
+		{"tointslice#3", From([]T{1, "2", 3}), nil, "query: element 1 is string, not int"},
+		{"tointslice#4", From([]T{nil}), nil, "query: element 0 is <nil>, not int"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.q.ToIntSlice()
+			if (err != nil || tt.wantErr != "") && (err == nil || err.Error() != tt.wantErr) {
+				t.Errorf("Query.ToIntSlice() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Query.ToIntSlice() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_ToStringSlice(t *testing.T) {
+	tests := []struct {
+		name    string
+		q       *Query
+		want    []string
+		wantErr string
+	}{
+		{"tostringslice#1", From([]T{}), []string{}, ""},
+		{"tostringslice#2", From([]T{"a", "b"}), []string{"a", "b"}, ""},
+		{"tostringslice#3", From([]T{"a", "b", 3}), nil, "query: element 2 is int, not string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.q.ToStringSlice()
+			if (err != nil || tt.wantErr != "") && (err == nil || err.Error() != tt.wantErr) {
+				t.Errorf("Query.ToStringSlice() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Query.ToStringSlice() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_TopN(t *testing.T) {
+	type args struct {
+		n int
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want *Query
+	}{
+		{"topn#1", From([]T{}), args{3}, From([]T{})},
+		{"topn#2", From(shuffle(span(1, 100))), args{3}, From([]T{100, 99, 98})},
+		{"topn#3", From(shuffle(span(1, 9))), args{9}, From(span(9, 1))},
+		{"topn#4", From(shuffle(span(1, 9))), args{100}, From(span(9, 1))},
+		{"topn#5", From(shuffle(span(1, 9))), args{0}, From([]T{})},
+		{"topn#6", From(shuffle(span(1, 9))), args{-1}, From([]T{})},
+		{"topn#7", From([]T{3, 1, 3, 2, 3}), args{2}, From([]T{3, 3})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.TopN(tt.args.n, less); !got.equal(tt.want) {
+				t.Errorf("Query.TopN() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_TryMapTo(t *testing.T) {
+	errThree := errors.New("three")
+	add := func(e T) (T, error) {
+		if e.(int) == 3 {
+			return nil, errThree
+		}
+		return e.(int) + 10, nil
+	}
+
+	type args struct {
+		f func(e T) (T, error)
+	}
+	tests := []struct {
+		name    string
+		q       *Query
+		args    args
+		want    *Query
+		wantErr error
+	}{
+		{"trymapto#1", From([]T{}), args{add}, From([]T{}), nil},
+		{"trymapto#2", From(span(1, 2)), args{add}, From([]T{11, 12}), nil},
+		{"trymapto#3", From(span(1, 9)), args{add}, From([]T{11, 12}), errThree},
+		{"trymapto#4", From(span(3, 9)), args{add}, From([]T{}), errThree},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.q.TryMapTo(tt.args.f)
+			if a, want := ToSlice(got), ToSlice(tt.want); !reflect.DeepEqual(a, want) {
+				t.Errorf("Query.TryMapTo() = %v, want %v", a, want)
+			}
+			if err := got.Err(); err != tt.wantErr {
+				t.Errorf("Query.TryMapTo() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestQuery_WeightedAverage(t *testing.T) {
+	type grade struct {
+		score, credits float64
+	}
+	grades := []T{grade{90, 4}, grade{60, 1}, grade{70, 0}}
+	score := func(e T) float64 {
+		return e.(grade).score
+	}
+	credits := func(e T) float64 {
+		return e.(grade).credits
+	}
+	one := func(e T) float64 {
+		return 1
+	}
+	type args struct {
+		valueSel  func(e T) float64
+		weightSel func(e T) float64
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want float64
+	}{
+		{"weightedaverage#1", From([]T{}), args{score, credits}, 0},
+		{"weightedaverage#2", From(grades), args{score, credits}, 84},
+		{"weightedaverage#3", From(grades), args{score, one}, 220.0 / 3},
+		{"weightedaverage#4", From(grades[2:]), args{score, credits}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.WeightedAverage(tt.args.valueSel, tt.args.weightSel); got != tt.want {
+				t.Errorf("Query.WeightedAverage() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestQuery_Where(t *testing.T) {
 	type args struct {
 		f []func(T) bool
@@ -868,6 +4429,11 @@ func TestQuery_Where(t *testing.T) {
 			args{[]func(T) bool{func(e T) bool {
 				return e.(int) > 9
 			}}}, From([]T{})},
+		{"where#12", From(span(1, 9)),
+			args{[]func(T) bool{nil, func(e T) bool {
+				return e.(int) < 4
+			}}}, From([]T{1, 2, 3})},
+		{"where#13", From(span(1, 9)), args{[]func(T) bool{nil}}, From(span(1, 9))},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -877,3 +4443,89 @@ func TestQuery_Where(t *testing.T) {
 		})
 	}
 }
+
+func TestQuery_WhereIndexed(t *testing.T) {
+	evenIndex := func(i int, e T) bool {
+		return i%2 == 0
+	}
+	firstFive := func(i int, e T) bool {
+		return i < 5
+	}
+
+	type args struct {
+		f func(i int, e T) bool
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want *Query
+	}{
+		{"whereindexed#1", From([]T{}), args{evenIndex}, From([]T{})},
+		{"whereindexed#2", From(span(1, 6)), args{evenIndex}, From([]T{1, 3, 5})},
+		{"whereindexed#3", From(span(1, 9)), args{firstFive}, From(span(1, 5))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.q.WhereIndexed(tt.args.f)
+			for i := 0; i < 2; i++ {
+				if !got.equal(tt.want) {
+					t.Errorf("Query.WhereIndexed() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestQuery_WhereNot(t *testing.T) {
+	lessFour := func(e T) bool {
+		return e.(int) < 4
+	}
+	odd := func(e T) bool {
+		return e.(int)%2 == 1
+	}
+	type args struct {
+		f []func(T) bool
+	}
+	tests := []struct {
+		name string
+		q    *Query
+		args args
+		want *Query
+	}{
+		{"wherenot#1", From([]T{}), args{}, From([]T{})},
+		{"wherenot#2", From(span(1, 9)), args{}, From([]T{})},
+		{"wherenot#3", From(span(1, 9)), args{[]func(T) bool{lessFour}}, From(span(4, 9))},
+		{"wherenot#4", From(span(1, 9)), args{[]func(T) bool{lessFour, odd}}, From([]T{2, 4, 5, 6, 7, 8, 9})},
+		{"wherenot#5", From(span(1, 9)), args{[]func(T) bool{truth(false)}}, From(span(1, 9))},
+		{"wherenot#6", From(span(1, 9)), args{[]func(T) bool{nil}}, From([]T{})},
+		{"wherenot#7", From(span(1, 9)), args{[]func(T) bool{nil, lessFour}}, From(span(4, 9))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.WhereNot(tt.args.f...); !got.equal(tt.want) {
+				t.Errorf("Query.WhereNot() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_WithPosition(t *testing.T) {
+	tests := []struct {
+		name string
+		q    *Query
+		want *Query
+	}{
+		{"withposition#1", From([]T{}), From([]T{})},
+		{"withposition#2", From([]T{1}), From([]T{Position{true, true, 1}})},
+		{"withposition#3", From(span(1, 2)), From([]T{Position{true, false, 1}, Position{false, true, 2}})},
+		{"withposition#4", From(span(1, 3)), From([]T{Position{true, false, 1}, Position{false, false, 2}, Position{false, true, 3}})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.WithPosition(); !got.equal(tt.want) {
+				t.Errorf("Query.WithPosition() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}